@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errorCodeKey :: the local 'SendResponse' stores the error code of the response in, for middleware that runs after the handler.
+const errorCodeKey = "error_code"
+
+// strikeCodes :: rejections that count as a strike, the client tried to shorten something we block.
+var strikeCodes = map[string]bool{
+	"UNSAFE_URL":         true,
+	"UNSUPPORTED_SCHEME": true,
+	"DENIED_ALIAS":       true,
+	"DENIED_NAMESPACE":   true,
+}
+
+const (
+	// abuseMaxTracked :: the most ips tracked at once. When it's reached the ones with neither recent strikes nor a ban
+	// get dropped, if that doesn't free a slot the ones that are done soonest.
+	abuseMaxTracked = 10000
+	// abuseSweepInterval :: how often the ips whose strikes and ban are over get dropped, full or not.
+	abuseSweepInterval = time.Minute
+)
+
+type offender struct {
+	strikes     int
+	since       time.Time
+	bannedUntil time.Time
+}
+
+// abuseGuard :: bans ips for a while (TLDR_ABUSE_BAN_SECONDS) that collect TLDR_ABUSE_STRIKES strikes within
+// TLDR_ABUSE_WINDOW_SECONDS. Everything lives in memory, a restart forgives everybody.
+// A nil guard is valid and bans nobody, that's what you get with TLDR_ABUSE_STRIKES unset.
+type abuseGuard struct {
+	mu        sync.Mutex
+	strikes   int
+	window    time.Duration
+	ban       time.Duration
+	offenders map[string]*offender
+}
+
+// newAbuseGuard :: create the guard for the configuration, nil if TLDR_ABUSE_STRIKES is 0.
+func newAbuseGuard(c config) *abuseGuard {
+	if c.AbuseStrikes == 0 {
+		return nil
+	}
+	g := &abuseGuard{
+		strikes:   c.AbuseStrikes,
+		window:    c.AbuseWindow,
+		ban:       c.AbuseBan,
+		offenders: make(map[string]*offender),
+	}
+	go g.sweep(abuseSweepInterval)
+	return g
+}
+
+// sweep :: prune the offenders every interval, so ips that struck once don't stay around forever.
+func (g *abuseGuard) sweep(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		g.mu.Lock()
+		g.prune(now)
+		g.mu.Unlock()
+	}
+}
+
+// BannedUntil :: returns the end of the ban if the ip is banned right now.
+func (g *abuseGuard) BannedUntil(ip string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	o, ok := g.offenders[ip]
+	if !ok || !time.Now().Before(o.bannedUntil) {
+		return time.Time{}, false
+	}
+	return o.bannedUntil, true
+}
+
+// Strike :: count a strike for the ip, returns true if it got the ip banned.
+// Strikes older than the window are forgotten, so do the strikes of an ip once its ban starts.
+func (g *abuseGuard) Strike(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	o, ok := g.offenders[ip]
+	if !ok {
+		if len(g.offenders) >= abuseMaxTracked {
+			g.prune(now)
+		}
+		// Evicting a tenth at once keeps a flood of new ips from sorting the map on every strike.
+		if len(g.offenders) >= abuseMaxTracked {
+			g.evictSoonestDone(abuseMaxTracked / 10)
+		}
+		o = &offender{}
+		g.offenders[ip] = o
+	}
+	if now.Sub(o.since) > g.window {
+		o.strikes, o.since = 0, now
+	}
+	o.strikes++
+	if o.strikes < g.strikes {
+		return false
+	}
+	o.strikes = 0
+	o.bannedUntil = now.Add(g.ban)
+	return true
+}
+
+// prune :: drop the ips whose strikes and ban are over, the caller holds the lock.
+func (g *abuseGuard) prune(now time.Time) {
+	for ip, o := range g.offenders {
+		if now.Sub(o.since) > g.window && !now.Before(o.bannedUntil) {
+			delete(g.offenders, ip)
+		}
+	}
+}
+
+// evictSoonestDone :: drop the count ips whose strikes or ban run out first, the caller holds the lock.
+func (g *abuseGuard) evictSoonestDone(count int) {
+	type tracked struct {
+		ip   string
+		done time.Time
+	}
+	all := make([]tracked, 0, len(g.offenders))
+	for ip, o := range g.offenders {
+		done := o.since.Add(g.window)
+		if o.bannedUntil.After(done) {
+			done = o.bannedUntil
+		}
+		all = append(all, tracked{ip, done})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].done.Before(all[j].done) })
+	for i := 0; i < count && i < len(all); i++ {
+		delete(g.offenders, all[i].ip)
+	}
+}
+
+// CheckBan :: middleware that answers every request of a banned ip with 403.
+func (g *abuseGuard) CheckBan(c *fiber.Ctx) error {
+	if g == nil {
+		return c.Next()
+	}
+	until, banned := g.BannedUntil(ClientIP(c))
+	if !banned {
+		return c.Next()
+	}
+	seconds := math.Ceil(time.Until(until).Seconds())
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Max(seconds, 1))))
+	msg := fmt.Sprintf("Too many blocked URLs, this client is banned until %s", until.UTC().Format(time.RFC3339))
+	return SendResponse(c, MakeErrorResponse(403, "BANNED", msg))
+}
+
+// CountStrikes :: middleware for the routes that create shorts, counts their blocked submissions as strikes.
+func (g *abuseGuard) CountStrikes(c *fiber.Ctx) error {
+	err := c.Next()
+	if g == nil {
+		return err
+	}
+	if code, _ := c.Locals(errorCodeKey).(string); strikeCodes[code] {
+		ip := ClientIP(c)
+		if g.Strike(ip) {
+			log.Printf("WARN: Banned %s for %s after %d blocked URLs", ip, g.ban, g.strikes)
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAbuseGuardStaysBounded(t *testing.T) {
+	g := &abuseGuard{strikes: 2, window: time.Hour, ban: 2 * time.Hour, offenders: make(map[string]*offender)}
+	// Every ip struck recently, pruning doesn't free anything.
+	for i := 0; i < abuseMaxTracked+100; i++ {
+		g.Strike(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+	if len(g.offenders) > abuseMaxTracked {
+		t.Errorf("%d ips are tracked, want at most %d", len(g.offenders), abuseMaxTracked)
+	}
+
+	// A ban outlives the strikes of the others, it is the last to go.
+	g.Strike("192.0.2.1")
+	if !g.Strike("192.0.2.1") {
+		t.Fatal("the second strike didn't ban")
+	}
+	for i := 0; i < abuseMaxTracked; i++ {
+		g.Strike(fmt.Sprintf("10.1.%d.%d", i/256, i%256))
+	}
+	if _, banned := g.BannedUntil("192.0.2.1"); !banned {
+		t.Error("the ban got evicted before ips that only struck once")
+	}
+}
+
+func TestAbuseGuardPrune(t *testing.T) {
+	g := &abuseGuard{strikes: 5, window: time.Minute, ban: time.Hour, offenders: make(map[string]*offender)}
+	g.Strike("192.0.2.1")
+	g.prune(time.Now().Add(2 * time.Minute))
+	if len(g.offenders) != 0 {
+		t.Errorf("%d ips are left after their window passed", len(g.offenders))
+	}
+}
+
+func TestClientIPWithoutProxyHeader(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          []string{"0.0.0.0"},
+		ProxyHeader:             fiber.HeaderXForwardedFor,
+	})
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(ClientIP(c))
+	})
+
+	tests := []struct {
+		forwarded string
+		want      string
+	}{
+		{"203.0.113.7", "203.0.113.7"},
+		{"198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		// app.Test connects from 0.0.0.0, the trusted proxy.
+		{"", "0.0.0.0"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.forwarded != "" {
+			req.Header.Set(fiber.HeaderXForwardedFor, test.forwarded)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		if got := string(body[:n]); got != test.want {
+			t.Errorf("X-Forwarded-For %q: client ip %q, want %q", test.forwarded, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	uri "net/url"
+)
+
+const (
+	// aliasCharset :: custom aliases may use digits, dashes and underscores on top of the generated charset.
+	aliasCharset   = charset + "0123456789-_"
+	maxShortLength = 64
+)
+
+// reservedShorts :: static route prefixes (and names we want to keep free for them), these can never be claimed as alias.
+var reservedShorts = []string{
+	"api",
+	"v1",
+	"s",
+	"health",
+	"metrics",
+	"livez",
+	"readyz",
+	"stats",
+	"docs",
+	"debug",
+	"export",
+	"purge",
+	"events",
+	"random",
+	"count",
+	"webhooks",
+	"reserve",
+	"exists",
+	"barcode",
+	"redirect-chain",
+	"openapi.json",
+}
+
+// lowerCharset :: the charset of generated shorts in case-insensitive mode.
+const lowerCharset = "abcdefghijklmnopqrstuvwxyz"
+
+// shortCharset :: the characters generated shorts are made of, only lowercase ones if TLDR_CASE_INSENSITIVE is set.
+func shortCharset() string {
+	if conf.CaseInsensitive {
+		return lowerCharset
+	}
+	return charset
+}
+
+// shortEquals :: the sql condition that matches the short column against a placeholder,
+// ignores the case if TLDR_CASE_INSENSITIVE is set.
+func shortEquals() string {
+	if conf.CaseInsensitive {
+		return `short = ? COLLATE NOCASE`
+	}
+	return `short = ?`
+}
+
+// canonicalShort :: the form shorts are stored and compared in, lowercase if TLDR_CASE_INSENSITIVE is set.
+func canonicalShort(short string) string {
+	if conf.CaseInsensitive {
+		return strings.ToLower(short)
+	}
+	return short
+}
+
+// ShortUrl :: returns the full, clickable url of the short, signed if TLDR_SIGNING_SECRET is set.
+func ShortUrl(c *fiber.Ctx, short string) string {
+	return shortUrlBase(c) + SignShort(short)
+}
+
+// shortUrlBase :: the part of a short url in front of the short.
+// The public base url comes from TLDR_BASE_URL, if that's unset the host of the request is used.
+func shortUrlBase(c *fiber.Ctx) string {
+	base := conf.BaseURL
+	if base == "" {
+		base = c.BaseURL()
+	}
+	return base + conf.BasePath + "/s/"
+}
+
+// IsReservedShort :: returns true if the short matches a built-in or configured (TLDR_RESERVED) reserved word, case-insensitive.
+func IsReservedShort(short string) bool {
+	for _, list := range [][]string{reservedShorts, conf.Reserved} {
+		for _, reserved := range list {
+			if strings.EqualFold(short, reserved) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// namespaceSeparator :: separates the namespace from the code of a short, 'team1/abc' is 'abc' in the namespace 'team1'.
+// The namespace is simply part of the stored short, so shorts are unique per namespace and lookups need nothing special.
+const namespaceSeparator = "/"
+
+// Namespaced :: the short of code in the namespace, just the code if there is no namespace.
+func Namespaced(namespace, code string) string {
+	if namespace == "" {
+		return code
+	}
+	return namespace + namespaceSeparator + code
+}
+
+// shortParam :: the ':short' route parameter, a namespaced short has to arrive with its '/' encoded ('team1%2Fabc').
+func shortParam(c *fiber.Ctx) string {
+	short, err := uri.PathUnescape(c.Params("short"))
+	if err != nil {
+		return c.Params("short")
+	}
+	return short
+}
+
+// IsValidShort :: returns true if the short is a valid code, optionally prefixed by a valid namespace ('team1/abc').
+// Anything else can never be a stored short, so there is no need to look it up.
+func IsValidShort(short string) bool {
+	for _, part := range strings.SplitN(short, namespaceSeparator, 2) {
+		if !IsValidCode(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidCode :: returns true if the code (or namespace) only consists of allowed characters and isn't too long.
+func IsValidCode(short string) bool {
+	if len(short) == 0 || len(short) > maxShortLength {
+		return false
+	}
+	for _, r := range short {
+		if !strings.ContainsRune(aliasCharset, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// CaseCollision :: returns a short that exists in more than one casing (e.g. 'abc' and 'ABC'), empty if there is none.
+// Such shorts would become ambiguous in case-insensitive mode.
+func (d database) CaseCollision() (string, error) {
+	var short string
+	query := `SELECT lower(short) FROM url GROUP BY lower(short) HAVING COUNT(*) > 1 LIMIT 1`
+
+	err := d.checkDb()
+	if err != nil {
+		return short, err
+	}
+
+	err = d.db.QueryRowContext(d.context(), query).Scan(&short)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return short, err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	aliasConflictReject = "reject"
+	aliasConflictUpdate = "update"
+)
+
+// mayUpdateAlias :: true if the request may point the existing url somewhere else,
+// it needs a valid api key that owns the url or an admin key.
+func mayUpdateAlias(c *fiber.Ctx, url Url) bool {
+	key := requestApiKey(c)
+	if !IsValidApiKey(key) {
+		return false
+	}
+	return IsAdminKey(key) || (url.Owner != "" && url.Owner == KeyOwner(key))
+}
+
+// updateTakenAlias :: with TLDR_ALIAS_CONFLICT=update a create with a taken alias changes the url of the alias instead,
+// if the caller owns it. Everything else the request asks for (expiry, password, ...) is ignored like on 'PutAlias'.
+func (h handler) updateTakenAlias(c *fiber.Ctx, short, target, original string, dryRun bool) error {
+	db := h.dbFor(c)
+	found, url, err := db.GetUrlFromShort(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	// Deleted in the meantime or not ours, either way the alias can't be updated by this request.
+	if !found || !mayUpdateAlias(c, url) {
+		msg := fmt.Sprintf("Alias '%s' is already taken.", short)
+		return SendResponse(c, MakeErrorResponse(409, "ALIAS_TAKEN", msg))
+	}
+
+	// A new target gains a short, like a create it has to stay within TLDR_MAX_ALIASES_PER_URL (dry runs too).
+	if url.Url != target {
+		if data, ok := h.checkAliasesPerUrl(c, target); !ok {
+			return SendResponse(c, data)
+		}
+	}
+
+	msg := "Ok"
+	if dryRun {
+		msg = "Dry run, nothing was updated"
+	} else if url.Url != target {
+		if _, err = db.UpdateUrl(short, target, original); err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+		}
+		log.Printf("INFO: Updated the url of the taken alias '%s' (request %s)", short, RequestID(c))
+	}
+	url.Url, url.OriginalUrl = target, original
+	url.ShortUrl = ShortUrl(c, url.Short)
+	data := MakeResponse(200, msg, url)
+	created := false
+	data.Created = &created
+	return SendResponse(c, data)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// EachClick :: call fn for every logged click of the short, oldest first. Stops at the first error of fn.
+func (d database) EachClick(short string, fn func(click) error) error {
+	query := `SELECT accessed_at, user_agent, referer, country FROM clicks_log WHERE ` + shortEquals() + ` ORDER BY accessed_at`
+
+	err := d.checkDb()
+	if err != nil {
+		return err
+	}
+
+	rows, err := d.db.QueryContext(d.context(), query, short)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var accessedAt int64
+		cl := click{short: short}
+		if err = rows.Scan(&accessedAt, &cl.userAgent, &cl.referer, &cl.country); err != nil {
+			return err
+		}
+		cl.accessedAt = time.Unix(accessedAt, 0).UTC()
+		if err = fn(cl); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ClickLogCSV :: download the raw click log of a short as csv, one row per click and oldest first.
+// User-Agent and Referer are only part of it while TLDR_LOG_PII is enabled, the ip is never stored in the first place.
+// Keys that aren't admin keys only get the click log of their own urls.
+func (h handler) ClickLogCSV(c *fiber.Ctx) error {
+	short := shortParam(c)
+	if !IsValidShort(short) {
+		return SendResponse(c, invalidShortResponse(short))
+	}
+	found, url, err := h.dbFor(c).GetUrlFromShort(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	} else if owner := listOwner(c); !found || (owner != "" && url.Owner != owner) {
+		msg := fmt.Sprintf("No URL found for short '%s'.", short)
+		return SendResponse(c, MakeResponse(404, msg, Url{}))
+	}
+
+	header := []string{"accessed_at", "country"}
+	if conf.LogPII {
+		header = []string{"accessed_at", "user_agent", "referer", "country"}
+	}
+	// Like the export the rows are written after the handler returned, don't touch 'c' in there.
+	requestID := RequestID(c)
+	db := h.db
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	// Shorts only consist of letters, digits, '-', '_' and the namespace '/', the latter can't be part of a file name.
+	filename := strings.ReplaceAll(url.Short, namespaceSeparator, "-") + "-clicks.csv"
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		out := csv.NewWriter(w)
+		out.Write(header)
+		rows := 0
+		err := db.EachClick(url.Short, func(cl click) error {
+			record := []string{cl.accessedAt.Format(time.RFC3339), cl.country}
+			if conf.LogPII {
+				record = []string{cl.accessedAt.Format(time.RFC3339), cl.userAgent, cl.referer, cl.country}
+			}
+			if err := out.Write(record); err != nil {
+				return err
+			}
+			rows++
+			if rows%exportFlushRows == 0 {
+				out.Flush()
+				if err := out.Error(); err != nil {
+					return err
+				}
+				return w.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("ERROR: Click log %s stopped after %d rows: %s", requestID, rows, err.Error())
+			return
+		}
+		out.Flush()
+		w.Flush()
+	})
+	return nil
+}
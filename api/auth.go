@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// AuthEnabled :: returns true if at least one api key is configured (TLDR_API_KEYS).
+func AuthEnabled() bool {
+	return len(conf.APIKeys) > 0
+}
+
+// requestApiKey :: returns the api key sent with the request, either as 'X-API-Key' or as bearer token.
+func requestApiKey(c *fiber.Ctx) string {
+	if key := c.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// IsValidApiKey :: returns true if the key matches a configured api key, the comparison runs in constant time.
+func IsValidApiKey(key string) bool {
+	valid := false
+	for _, configured := range conf.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(configured)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// showClicks :: returns true if the response may contain click counts, always for authenticated requests
+// and for everyone else only with TLDR_EXPOSE_CLICKS.
+func showClicks(c *fiber.Ctx) bool {
+	return conf.ExposeClicks || IsValidApiKey(requestApiKey(c))
+}
+
+// requireListAuth :: middleware for routes that enumerate urls, they need an api key unless TLDR_LIST_PUBLIC is set.
+func requireListAuth(c *fiber.Ctx) error {
+	if conf.ListPublic {
+		return c.Next()
+	}
+	return requireAuth(c)
+}
+
+// requireClicksAuth :: middleware for routes that are all about clicks, they need an api key unless TLDR_EXPOSE_CLICKS is set.
+func requireClicksAuth(c *fiber.Ctx) error {
+	if conf.ExposeClicks {
+		return c.Next()
+	}
+	return requireAuth(c)
+}
+
+// requireAuth :: middleware that only lets requests with a valid api key through.
+// Routes guarded by it are unreachable while auth is disabled.
+func requireAuth(c *fiber.Ctx) error {
+	if !AuthEnabled() {
+		data := MakeErrorResponse(403, "AUTH_DISABLED", "This route requires authentication, but no api keys are configured")
+		return SendResponse(c, data)
+	}
+	if !IsValidApiKey(requestApiKey(c)) {
+		data := MakeErrorResponse(401, "UNAUTHORIZED", "Missing or invalid api key")
+		return SendResponse(c, data)
+	}
+	return c.Next()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// code128StartB, code128Stop :: the symbols around the data, code set B covers printable ascii (all a short url needs).
+	code128StartB = 104
+	code128Stop   = 106
+	// barcodeQuietZone :: blank modules left and right of the bars, scanners need them to find the start.
+	barcodeQuietZone = 10
+)
+
+// code128Patterns :: bar and space widths (in modules) of every code 128 symbol, starting with a bar.
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// ErrNotEncodable :: the text has characters code set B doesn't have.
+var ErrNotEncodable = errors.New("only printable ascii can be encoded as code 128")
+
+// code128Modules :: encode the text with code set B, returns one bool per module, true for a bar.
+// The quiet zones are included.
+func code128Modules(text string) ([]bool, error) {
+	symbols := []int{code128StartB}
+	checksum := code128StartB
+	for i := 0; i < len(text); i++ {
+		if text[i] < 32 || text[i] > 126 {
+			return nil, ErrNotEncodable
+		}
+		value := int(text[i]) - 32
+		symbols = append(symbols, value)
+		checksum += (i + 1) * value
+	}
+	symbols = append(symbols, checksum%103, code128Stop)
+
+	modules := make([]bool, barcodeQuietZone)
+	for _, symbol := range symbols {
+		for i, width := range code128Patterns[symbol] {
+			for w := 0; w < int(width-'0'); w++ {
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	return append(modules, make([]bool, barcodeQuietZone)...), nil
+}
+
+// code128PNG :: the text as code 128 barcode, every module scale pixels wide and the bars height pixels high.
+func code128PNG(text string, scale, height int) ([]byte, error) {
+	modules, err := code128Modules(text)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewPaletted(image.Rect(0, 0, len(modules)*scale, height), color.Palette{color.White, color.Black})
+	for x := range modules {
+		if !modules[x] {
+			continue
+		}
+		for px := x * scale; px < (x+1)*scale; px++ {
+			for y := 0; y < height; y++ {
+				img.SetColorIndex(px, y, 1)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// barcodeParam :: read an optional integer query parameter within min and max, returns the response to send if it's invalid.
+func barcodeParam(c *fiber.Ctx, name string, fallback, min, max int) (int, Data, bool) {
+	if c.Query(name) == "" {
+		return fallback, Data{}, true
+	}
+	value, err := strconv.Atoi(c.Query(name))
+	if err != nil || value < min || value > max {
+		msg := fmt.Sprintf("%s must be a number between %d and %d", name, min, max)
+		return 0, MakeResponse(400, msg, Url{}), false
+	}
+	return value, Data{}, true
+}
+
+// Barcode :: the short url as code 128 barcode (png), for printed labels and linear scanners.
+// '?scale=' sets the width of the narrowest bar in pixels (default 2, max 10), '?height=' the height (default 80, 10 to 1000).
+// With TLDR_SIGNING_SECRET the short needs its signature like on resolve, else the barcode would hand the signature out.
+func (h handler) Barcode(c *fiber.Ctx) error {
+	short, ok := VerifyShort(shortParam(c))
+	if !ok {
+		return SendResponse(c, MakeErrorResponse(403, "INVALID_SIGNATURE", "The short is not signed or the signature doesn't match"))
+	}
+	if !IsValidShort(short) {
+		return SendResponse(c, invalidShortResponse(short))
+	}
+	scale, data, ok := barcodeParam(c, "scale", 2, 1, 10)
+	if !ok {
+		return SendResponse(c, data)
+	}
+	height, data, ok := barcodeParam(c, "height", 80, 10, 1000)
+	if !ok {
+		return SendResponse(c, data)
+	}
+
+	found, url, err := h.dbFor(c).GetUrlFromShort(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	} else if !found {
+		msg := fmt.Sprintf("No URL found for short '%s'.", short)
+		return SendResponse(c, MakeResponse(404, msg, Url{}))
+	}
+
+	image, err := code128PNG(ShortUrl(c, url.Short), scale, height)
+	if errors.Is(err, ErrNotEncodable) {
+		return SendResponse(c, MakeResponse(422, "The short url contains characters a barcode can't hold", Url{}))
+	} else if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	c.Set(fiber.HeaderContentType, "image/png")
+	return c.Send(image)
+}
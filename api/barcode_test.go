@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// Module patterns of the code 128 standard, written out as bars (1) and spaces (0).
+const (
+	startB = "11010010000"
+	stop   = "1100011101011"
+)
+
+// bars :: the modules as string of bars and spaces, without the quiet zones.
+func bars(t *testing.T, modules []bool) string {
+	t.Helper()
+	for _, side := range [][]bool{modules[:barcodeQuietZone], modules[len(modules)-barcodeQuietZone:]} {
+		for _, bar := range side {
+			if bar {
+				t.Fatal("the quiet zone has a bar")
+			}
+		}
+	}
+	var b strings.Builder
+	for _, bar := range modules[barcodeQuietZone : len(modules)-barcodeQuietZone] {
+		if bar {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func TestCode128Modules(t *testing.T) {
+	tests := []struct {
+		text string
+		// want is start, data, checksum and stop symbol.
+		want []string
+	}{
+		// Checksum (104 + 33) % 103 = 34, 'B'.
+		{"A", []string{startB, "10100011000", "10001011000", stop}},
+		// Checksum (104 + 33 + 2*34) % 103 = 102, FNC1.
+		{"AB", []string{startB, "10100011000", "10001011000", "11110101110", stop}},
+		// Checksum (104 + 0) % 103 = 1, '!'.
+		{" ", []string{startB, "11011001100", "11001101100", stop}},
+		// Checksum (104 + 16 + 2*17) % 103 = 51, 'S'.
+		{"01", []string{startB, "10011101100", "10011100110", "11011101000", stop}},
+		// No data, the checksum is the start symbol alone: 104 % 103 = 1.
+		{"", []string{startB, "11001101100", stop}},
+	}
+	for _, test := range tests {
+		modules, err := code128Modules(test.text)
+		if err != nil {
+			t.Fatalf("code128Modules(%q): %s", test.text, err.Error())
+		}
+		if got, want := bars(t, modules), strings.Join(test.want, ""); got != want {
+			t.Errorf("code128Modules(%q) =\n%s, want\n%s", test.text, got, want)
+		}
+	}
+}
+
+func TestCode128ModulesNotEncodable(t *testing.T) {
+	for _, text := range []string{"tab\t", "new\nline", "äöü", "\x7f"} {
+		if _, err := code128Modules(text); err != ErrNotEncodable {
+			t.Errorf("code128Modules(%q) = %v, want ErrNotEncodable", text, err)
+		}
+	}
+}
+
+func TestCode128Patterns(t *testing.T) {
+	seen := make(map[string]int)
+	for symbol, pattern := range code128Patterns {
+		want := 11
+		if symbol == code128Stop {
+			want = 13
+		}
+		width := 0
+		for _, w := range pattern {
+			width += int(w - '0')
+		}
+		if width != want {
+			t.Errorf("symbol %d is %d modules wide, want %d", symbol, width, want)
+		}
+		if other, ok := seen[pattern]; ok {
+			t.Errorf("symbols %d and %d have the same pattern %s", other, symbol, pattern)
+		}
+		seen[pattern] = symbol
+	}
+}
+
+func TestCode128PNG(t *testing.T) {
+	text := "https://tl.dr/abc"
+	modules, err := code128Modules(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := code128PNG(text, 3, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != len(modules)*3 || size.Y != 40 {
+		t.Fatalf("image is %dx%d, want %dx40", size.X, size.Y, len(modules)*3)
+	}
+	for x, bar := range modules {
+		for px := x * 3; px < (x+1)*3; px++ {
+			r, _, _, _ := img.At(px, 20).RGBA()
+			if (r == 0) != bar {
+				t.Fatalf("pixel %d doesn't match module %d", px, x)
+			}
+		}
+	}
+}
+
+func TestBarcodeSignedShort(t *testing.T) {
+	app, db := newTestApp(t, map[string]string{"TLDR_SIGNING_SECRET": "secret"})
+	if err := db.InsertNewUrl(Url{Short: "abc", Url: "https://example.com", Valid: StatusActive}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		short  string
+		status int
+	}{
+		{SignShort("abc"), 200},
+		// The bare short must not be a way to get its signature.
+		{"abc", 403},
+		{"abc" + signatureSeparator + "000000", 403},
+	}
+	for _, test := range tests {
+		resp, _ := sendRaw(t, app, "GET", "/api/"+test.short+"/barcode", "")
+		if resp.StatusCode != test.status {
+			t.Errorf("barcode of %q: %d, want %d", test.short, resp.StatusCode, test.status)
+		} else if test.status == 200 && resp.Header.Get("Content-Type") != "image/png" {
+			t.Errorf("barcode of %q is %s, want image/png", test.short, resp.Header.Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const (
+	clickQueueSize   = 1024
+	defaultStatsDays = 30
+	maxStatsDays     = 365
+	secondsPerDay    = 24 * 60 * 60
+	maxHeaderLength  = 512
+	topReferrerCount = 10
+)
+
+type click struct {
+	short      string
+	accessedAt time.Time
+	userAgent  string
+	referer    string
+	// ip is only used to look up the country, it never gets stored.
+	ip      string
+	country string
+}
+
+// DailyClicks :: the number of clicks a short received on a single day (UTC).
+type DailyClicks struct {
+	Date  string
+	Count int
+}
+
+// RefererClicks :: the number of clicks a short received from a single referer.
+type RefererClicks struct {
+	Referer string
+	Count   int
+}
+
+// CountryClicks :: the number of clicks a short received from a single country (ISO code or 'unknown').
+type CountryClicks struct {
+	Country string
+	Count   int
+}
+
+// ClickStats :: the click history of a short.
+type ClickStats struct {
+	Short        string
+	Days         []DailyClicks
+	TopReferrers []RefererClicks
+	Countries    []CountryClicks
+}
+
+// LinkStats :: the current numbers of a short in a batch, see 'BatchStats'.
+type LinkStats struct {
+	Found  bool
+	Clicks int
+	// LastAccessed is the time of the latest logged click, nil if there was none.
+	LastAccessed *time.Time `json:",omitempty"`
+	Valid        bool
+}
+
+// clickLogger :: writes clicks to the database in the background so resolving a short never waits for the insert.
+type clickLogger struct {
+	db     database
+	geo    *geoLocator
+	clicks chan click
+}
+
+// newClickLogger :: create the logger and start the worker that drains the queue.
+func newClickLogger(db database, geo *geoLocator) *clickLogger {
+	l := &clickLogger{
+		db:     db,
+		geo:    geo,
+		clicks: make(chan click, clickQueueSize),
+	}
+	go l.run()
+	return l
+}
+
+// Log :: queue a click for the short, if the queue is full the click is dropped rather than blocking the request.
+// User-Agent and Referer are only captured if PII logging is enabled (TLDR_LOG_PII).
+func (l *clickLogger) Log(c *fiber.Ctx, short string) {
+	cl := click{short: short, accessedAt: time.Now()}
+	if conf.LogPII {
+		// The header values point into the request buffer, which gets reused after the request, so copy them.
+		cl.userAgent = utils.CopyString(truncate(c.Get(fiber.HeaderUserAgent), maxHeaderLength))
+		cl.referer = utils.CopyString(truncate(c.Get(fiber.HeaderReferer), maxHeaderLength))
+	}
+	if l.geo != nil {
+		cl.ip = utils.CopyString(ClientIP(c))
+	}
+
+	select {
+	case l.clicks <- cl:
+	default:
+		log.Printf("WARN: Click queue is full, dropping click for '%s'", short)
+	}
+}
+
+// run :: insert the queued clicks one by one, the country lookup happens here so it never slows down a redirect.
+func (l *clickLogger) run() {
+	for c := range l.clicks {
+		c.country = l.geo.Country(c.ip)
+		err := l.db.InsertClick(c)
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+		}
+	}
+}
+
+// InsertClick :: add a click to the 'clicks_log', linked to its url.
+// Clicks are logged in the background, if the url got deleted in the meantime there is nothing to link to and nothing is stored.
+func (d database) InsertClick(c click) error {
+	query := `INSERT INTO clicks_log (short, url_id, accessed_at, user_agent, referer, country)
+			  SELECT short, ID, ?, ?, ?, ? FROM url WHERE short = ?`
+
+	err := d.checkDb()
+	if err != nil {
+		return err
+	}
+
+	return withRetry(func() error {
+		_, err := d.db.ExecContext(d.context(), query, c.accessedAt.Unix(), c.userAgent, c.referer, c.country, c.short)
+		return err
+	})
+}
+
+// truncate :: cut the string down to at most max bytes, on a rune boundary so no character is cut in half.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// statsWindowStart :: returns the unix time of midnight (UTC) of the first day in a window of 'days' days ending today.
+func statsWindowStart(days int) int64 {
+	today := time.Now().UTC().Truncate(secondsPerDay * time.Second)
+	return today.AddDate(0, 0, -(days - 1)).Unix()
+}
+
+// GetDailyClicks :: count the clicks of the short per day for the last 'days' days (including today), days without clicks are left out.
+func (d database) GetDailyClicks(short string, days int) ([]DailyClicks, error) {
+	stats := []DailyClicks{}
+	query := `SELECT date(accessed_at, 'unixepoch') AS day, COUNT(*) FROM clicks_log
+			  WHERE ` + shortEquals() + ` AND accessed_at >= ? GROUP BY day ORDER BY day`
+
+	err := d.checkDb()
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := d.db.QueryContext(d.context(), query, short, statsWindowStart(days))
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tmp DailyClicks
+		err = rows.Scan(&tmp.Date, &tmp.Count)
+		if err != nil {
+			return stats, err
+		}
+		stats = append(stats, tmp)
+	}
+
+	return stats, rows.Err()
+}
+
+// GetTopReferrers :: returns the referrers that sent the most clicks to the short in the last 'days' days.
+func (d database) GetTopReferrers(short string, days int) ([]RefererClicks, error) {
+	referrers := []RefererClicks{}
+	query := `SELECT referer, COUNT(*) AS clicks FROM clicks_log
+			  WHERE ` + shortEquals() + ` AND accessed_at >= ? AND referer != ''
+			  GROUP BY referer ORDER BY clicks DESC LIMIT ?`
+
+	err := d.checkDb()
+	if err != nil {
+		return referrers, err
+	}
+
+	rows, err := d.db.QueryContext(d.context(), query, short, statsWindowStart(days), topReferrerCount)
+	if err != nil {
+		return referrers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tmp RefererClicks
+		err = rows.Scan(&tmp.Referer, &tmp.Count)
+		if err != nil {
+			return referrers, err
+		}
+		referrers = append(referrers, tmp)
+	}
+
+	return referrers, rows.Err()
+}
+
+// GetCountryClicks :: count the clicks of the short per country in the last 'days' days, most clicks first.
+func (d database) GetCountryClicks(short string, days int) ([]CountryClicks, error) {
+	countries := []CountryClicks{}
+	query := `SELECT country, COUNT(*) AS clicks FROM clicks_log
+			  WHERE ` + shortEquals() + ` AND accessed_at >= ?
+			  GROUP BY country ORDER BY clicks DESC, country`
+
+	err := d.checkDb()
+	if err != nil {
+		return countries, err
+	}
+
+	rows, err := d.db.QueryContext(d.context(), query, short, statsWindowStart(days))
+	if err != nil {
+		return countries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tmp CountryClicks
+		err = rows.Scan(&tmp.Country, &tmp.Count)
+		if err != nil {
+			return countries, err
+		}
+		if tmp.Country == "" {
+			tmp.Country = unknownCountry
+		}
+		countries = append(countries, tmp)
+	}
+
+	return countries, rows.Err()
+}
+
+// GetBatchStats :: the stats of many shorts with a single query, keyed by their canonical short, only those of owner if it is set.
+// Shorts that don't exist are missing from the map.
+func (d database) GetBatchStats(shorts []string, owner string) (map[string]LinkStats, error) {
+	stats := make(map[string]LinkStats)
+	if len(shorts) == 0 {
+		return stats, nil
+	}
+
+	err := d.checkDb()
+	if err != nil {
+		return stats, err
+	}
+
+	in, args := shortsIn(shorts)
+	if owner != "" {
+		in += ` AND owner = ?`
+		args = append(args, owner)
+	}
+	query := `SELECT short, valid, uses, max_uses, active_from, expires_at,
+			  (SELECT MAX(accessed_at) FROM clicks_log WHERE clicks_log.url_id = url.ID)
+			  FROM url WHERE ` + in
+	rows, err := d.db.QueryContext(d.context(), query, args...)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url Url
+		var maxUses, activeFrom, expiresAt, lastAccessed sql.NullInt64
+		err = rows.Scan(&url.Short, &url.Valid, &url.Uses, &maxUses, &activeFrom, &expiresAt, &lastAccessed)
+		if err != nil {
+			return stats, err
+		}
+		url.MaxUses = int(maxUses.Int64)
+		url.ActiveFrom = timeFromNull(activeFrom)
+		url.ExpiresAt = timeFromNull(expiresAt)
+		stats[canonicalShort(url.Short)] = LinkStats{
+			Found:        true,
+			Clicks:       url.Uses,
+			LastAccessed: timeFromNull(lastAccessed),
+			Valid:        IsValid(url) && !IsUsedUp(url) && !IsExpired(url) && IsActive(url),
+		}
+	}
+	return stats, rows.Err()
+}
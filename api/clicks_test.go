@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"Mozilla/5.0", 7, "Mozilla"},
+		// 'ü' and 'ö' are two bytes, '€' three and the emoji four, none of them may be cut in half.
+		{"grüße", 3, "gr"},
+		{"grüße", 4, "grü"},
+		{"10€", 4, "10"},
+		{"10€", 5, "10€"},
+		{"😀😀", 7, "😀"},
+		{"ö", 1, ""},
+	}
+	for _, test := range tests {
+		got := truncate(test.s, test.max)
+		if got != test.want || !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q, want %q", test.s, test.max, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// collisionWindow :: the number of recent short generations the collision rate is computed from.
+const collisionWindow = 1000
+
+// GenerationStats :: how often generated shorts were already taken, the higher the rate the fuller the short space.
+type GenerationStats struct {
+	Generations   int64
+	Collisions    int64
+	CollisionRate float64
+}
+
+// generationCounter :: counts the generations of 'PrepareNewUrl' and the collisions they ran into.
+// The totals count since the start, the rate only looks at the last 'collisionWindow' generations.
+type generationCounter struct {
+	mu          sync.Mutex
+	generations int64
+	collisions  int64
+	recent      [collisionWindow]int
+	next        int
+	filled      bool
+}
+
+var shortGenerations = &generationCounter{}
+
+// Record :: account for a generation that ran into collisions taken shorts, returns the current collision rate.
+func (g *generationCounter) Record(collisions int) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.generations++
+	g.collisions += int64(collisions)
+	g.recent[g.next] = collisions
+	g.next = (g.next + 1) % collisionWindow
+	if g.next == 0 {
+		g.filled = true
+	}
+	return g.rate()
+}
+
+// rate :: collisions per generated short over the recent generations, the caller holds the lock.
+func (g *generationCounter) rate() float64 {
+	count := g.next
+	if g.filled {
+		count = collisionWindow
+	}
+	collisions := 0
+	for _, c := range g.recent[:count] {
+		collisions += c
+	}
+	if count == 0 {
+		return 0
+	}
+	return float64(collisions) / float64(count+collisions)
+}
+
+// Stats :: the current numbers, for /readyz and /debug/vars.
+func (g *generationCounter) Stats() GenerationStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return GenerationStats{Generations: g.generations, Collisions: g.collisions, CollisionRate: g.rate()}
+}
+
+// recordGeneration :: count the generation and warn once the recent collision rate reaches TLDR_COLLISION_WARN_PERCENT.
+func recordGeneration(collisions int) {
+	rate := shortGenerations.Record(collisions)
+	if collisions > 0 && rate*100 >= float64(conf.CollisionWarnPercent) {
+		log.Printf("WARN: Short generation ran into %d taken shorts, %.1f%% of the recent attempts collided, consider longer shorts",
+			collisions, rate*100)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// encodeTarget :: the value to store in the url column and whether it is compressed.
+// Targets of at least TLDR_COMPRESS_MIN_LENGTH bytes are stored gzipped, unless that doesn't make them smaller.
+func encodeTarget(target string) (interface{}, bool, error) {
+	if conf.CompressMinLength == 0 || len(target) < conf.CompressMinLength {
+		return target, false, nil
+	}
+
+	var buf bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err = writer.Write([]byte(target)); err != nil {
+		return nil, false, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, false, err
+	}
+	if buf.Len() >= len(target) {
+		return target, false, nil
+	}
+	return buf.Bytes(), true, nil
+}
+
+// decodeTarget :: the reverse of 'encodeTarget'.
+func decodeTarget(stored []byte, compressed bool) (string, error) {
+	if !compressed {
+		return string(stored), nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	target, err := io.ReadAll(reader)
+	return string(target), err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompressedUrls(t *testing.T) {
+	app, db := newTestApp(t, map[string]string{"TLDR_COMPRESS_MIN_LENGTH": "100"})
+	long := "https://example.com/deep/link?" + strings.Repeat("utm_campaign=spring&ref=newsletter&", 50) + "id=5"
+	tests := []struct {
+		url        string
+		compressed bool
+	}{
+		{long, true},
+		{"https://example.com/short", false},
+		// Long enough, but gzip doesn't make it any smaller.
+		{"https://ex.com/?a=1&b=2&c=3&d=4&e=5&f=6&g=7&h=8&i=9&j=0&k=Q&l=W&m=E&n=R&o=T&p=Y&q=U&r=I&s=O&t=P&u=Z&v=X", false},
+	}
+	for i, test := range tests {
+		short := create(t, app, fmt.Sprintf(`{"url": %q}`, test.url))
+
+		var compressed bool
+		if err := db.db.QueryRow(`SELECT compressed FROM url WHERE short = ?`, short).Scan(&compressed); err != nil {
+			t.Fatal(err)
+		}
+		if compressed != test.compressed {
+			t.Errorf("url %d is stored compressed %v, want %v", i, compressed, test.compressed)
+		}
+		if status, resp := send(t, app, "GET", "/api/"+short, ""); status != 200 || resp.Data["Url"] != test.url {
+			t.Errorf("url %d resolved to %d %v", i, status, resp.Data["Url"])
+		}
+		if resp, _ := sendRaw(t, app, "GET", "/s/"+short, ""); resp.Header.Get("Location") != test.url {
+			t.Errorf("url %d redirects to %s", i, resp.Header.Get("Location"))
+		}
+	}
+}
+
+func TestEncodeTarget(t *testing.T) {
+	newTestApp(t, map[string]string{"TLDR_COMPRESS_MIN_LENGTH": "10"})
+	for _, target := range []string{"https://x", strings.Repeat("https://example.com/", 20), "https://example.com/äöü?q=" + strings.Repeat("ü", 30)} {
+		stored, compressed, err := encodeTarget(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var raw []byte
+		switch v := stored.(type) {
+		case string:
+			raw = []byte(v)
+		case []byte:
+			raw = v
+		}
+		if got, err := decodeTarget(raw, compressed); err != nil || got != target {
+			t.Errorf("%q came back as %q (%v)", target, got, err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	uri "net/url"
+)
+
+// config :: runtime settings, read from the environment on startup.
+type config struct {
+	Reserved      []string
+	Denylist      []string
+	APIKeys       []string
+	AdminKeys     []string
+	BaseURL       string
+	BasePath      string
+	DBBusyTimeout int
+	DBMaxConns    int
+	// DBConnectAttempts and DBConnectBackoff control how long the startup waits for the database.
+	DBConnectAttempts int
+	DBConnectBackoff  time.Duration
+	Maintenance       time.Duration
+	Timeout           time.Duration
+	EnrichConcurrency int
+	MaxRedirects      int
+	JSONStyle         string
+	CacheSize         int
+	VerifyOnCreate    bool
+	RequireHTTPS      bool
+	MaxAliasesPerUrl  int
+	// AliasConflict decides what a create with a taken alias does, see 'updateTakenAlias'.
+	AliasConflict string
+	// AllowPrivateTargets lets the outbound requests (favicon, target checks, ...) reach private addresses, see 'refusePrivate'.
+	AllowPrivateTargets bool
+	// AbuseStrikes blocked submissions within AbuseWindow get the client banned for AbuseBan, see 'abuseGuard'.
+	AbuseStrikes int
+	AbuseWindow  time.Duration
+	AbuseBan     time.Duration
+	// CollisionWarnPercent is the recent collision rate from which on generated shorts that collided get logged.
+	CollisionWarnPercent int
+	// ShortenerMode decides what happens to targets on one of the ShortenerHosts, see 'checkShortener'.
+	ShortenerMode     string
+	ShortenerHosts    []string
+	LogPII            bool
+	Debug             bool
+	ListPublic        bool
+	ExposeClicks      bool
+	LogTimeZone       string
+	LogTimeFormat     string
+	LogSkip           []string
+	Favicon           bool
+	Landing           bool
+	DefaultTTL        time.Duration
+	MaxLinks          int
+	CompressMinLength int
+	KeepOriginalUrl   bool
+
+	CaseInsensitive bool
+	// ConstantTimeLookup makes unknown shorts take as long as known ones, see 'lookupTimer'.
+	ConstantTimeLookup bool
+
+	SafeBrowsingKey    string
+	ReputationFailOpen bool
+
+	WebhookURL string
+	// WebhookAttempts is how often a delivery is tried, WebhookBackoff the delay before the first retry (it doubles).
+	WebhookAttempts int
+	WebhookBackoff  time.Duration
+	// ExpiryNotice is how long before their expiry urls get announced to the webhook, 0 never announces them.
+	ExpiryNotice time.Duration
+	// ExpiredRedirect and InvalidRedirect replace the error of expired/used up and disabled shorts on redirect.
+	ExpiredRedirect string
+	InvalidRedirect string
+	WebhookSecret   string
+	SigningSecret   string
+
+	TrustedProxies []string
+	ProxyHeader    string
+
+	GeoIPDB string
+
+	TLSCert       string
+	TLSKey        string
+	TLSMinVersion string
+
+	// SecurityHeaders enables 'securityHeaders', HSTSMaxAge 0 leaves out HSTS.
+	SecurityHeaders bool
+	ReferrerPolicy  string
+	HSTSMaxAge      int
+}
+
+var conf config
+
+// loadConfig :: read the configuration from the environment, unset variables fall back to their defaults.
+func loadConfig() config {
+	var c config
+
+	c.Reserved = getEnvList("TLDR_RESERVED", "")
+	if path := getEnv("TLDR_DENYLIST_FILE", ""); path != "" {
+		var err error
+		if c.Denylist, err = loadDenylist(path); err != nil {
+			log.Fatalf("Could not read TLDR_DENYLIST_FILE: %s", err.Error())
+		}
+	}
+	c.APIKeys = getEnvList("TLDR_API_KEYS", "")
+	// Admin keys are api keys as well, they don't have to be listed twice.
+	c.AdminKeys = getEnvList("TLDR_ADMIN_KEYS", "")
+	c.APIKeys = append(c.APIKeys, c.AdminKeys...)
+	c.BaseURL = strings.TrimSuffix(getEnv("TLDR_BASE_URL", ""), "/")
+	c.BasePath = strings.TrimSuffix(getEnv("TLDR_BASE_PATH", ""), "/")
+	if c.BasePath != "" && !strings.HasPrefix(c.BasePath, "/") {
+		c.BasePath = "/" + c.BasePath
+	}
+	c.DBBusyTimeout = getEnvInt("TLDR_DB_BUSY_TIMEOUT", 5000)
+	c.DBMaxConns = getEnvInt("TLDR_DB_MAX_CONNS", 0)
+	c.DBConnectAttempts = getEnvInt("TLDR_DB_CONNECT_ATTEMPTS", 5)
+	c.DBConnectBackoff = time.Duration(getEnvInt("TLDR_DB_CONNECT_BACKOFF", 500)) * time.Millisecond
+	c.Timeout = time.Duration(getEnvInt("TLDR_REQUEST_TIMEOUT", 10)) * time.Second
+	c.Maintenance = time.Duration(getEnvInt("TLDR_MAINTENANCE_INTERVAL_SECONDS", 0)) * time.Second
+	c.EnrichConcurrency = getEnvInt("TLDR_ENRICH_CONCURRENCY", 8)
+	c.MaxRedirects = getEnvInt("TLDR_MAX_REDIRECTS", 10)
+	c.JSONStyle = strings.ToLower(getEnv("TLDR_JSON_STYLE", jsonStylePascal))
+	c.CacheSize = getEnvInt("TLDR_CACHE_SIZE", 1000)
+	c.VerifyOnCreate = getEnvBool("TLDR_VERIFY_ON_CREATE", false)
+	c.AllowPrivateTargets = getEnvBool("TLDR_ALLOW_PRIVATE_TARGETS", false)
+	c.RequireHTTPS = getEnvBool("TLDR_REQUIRE_HTTPS", false)
+	c.ConstantTimeLookup = getEnvBool("TLDR_CONSTANT_TIME_LOOKUP", false)
+	c.MaxAliasesPerUrl = getEnvInt("TLDR_MAX_ALIASES_PER_URL", 0)
+	c.AliasConflict = strings.ToLower(getEnv("TLDR_ALIAS_CONFLICT", aliasConflictReject))
+	c.AbuseStrikes = getEnvInt("TLDR_ABUSE_STRIKES", 0)
+	c.AbuseWindow = time.Duration(getEnvInt("TLDR_ABUSE_WINDOW_SECONDS", 600)) * time.Second
+	c.AbuseBan = time.Duration(getEnvInt("TLDR_ABUSE_BAN_SECONDS", 3600)) * time.Second
+	c.CollisionWarnPercent = getEnvInt("TLDR_COLLISION_WARN_PERCENT", 0)
+	c.ShortenerMode = strings.ToLower(getEnv("TLDR_SHORTENER_MODE", shortenerModeOff))
+	c.ShortenerHosts = getEnvList("TLDR_SHORTENER_HOSTS", defaultShortenerHosts)
+	c.LogPII = getEnvBool("TLDR_LOG_PII", true)
+	c.Debug = getEnvBool("TLDR_DEBUG", false)
+	c.ListPublic = getEnvBool("TLDR_LIST_PUBLIC", false)
+	c.ExposeClicks = getEnvBool("TLDR_EXPOSE_CLICKS", false)
+	c.LogTimeZone = getEnv("TLDR_LOG_TIMEZONE", "UTC")
+	c.LogSkip = getEnvList("TLDR_LOG_SKIP", "/health,/livez,/readyz,/metrics")
+	c.LogTimeFormat = getEnv("TLDR_LOG_TIME_FORMAT", "Jan-02-2006")
+	c.Favicon = getEnvBool("TLDR_FAVICON", true)
+	c.Landing = getEnvBool("TLDR_LANDING", true)
+	c.DefaultTTL = time.Duration(getEnvInt("TLDR_DEFAULT_TTL_SECONDS", 0)) * time.Second
+	c.MaxLinks = getEnvInt("TLDR_MAX_LINKS", 0)
+	c.CompressMinLength = getEnvInt("TLDR_COMPRESS_MIN_LENGTH", 0)
+	c.KeepOriginalUrl = getEnvBool("TLDR_KEEP_ORIGINAL_URL", true)
+	c.CaseInsensitive = getEnvBool("TLDR_CASE_INSENSITIVE", false)
+	c.SafeBrowsingKey = getEnv("TLDR_SAFE_BROWSING_KEY", "")
+	c.ReputationFailOpen = getEnvBool("TLDR_REPUTATION_FAIL_OPEN", true)
+	c.WebhookURL = getEnv("TLDR_WEBHOOK_URL", "")
+	c.WebhookAttempts = getEnvInt("TLDR_WEBHOOK_ATTEMPTS", 3)
+	c.WebhookBackoff = time.Duration(getEnvInt("TLDR_WEBHOOK_BACKOFF_MS", 1000)) * time.Millisecond
+	c.ExpiryNotice = time.Duration(getEnvInt("TLDR_EXPIRY_NOTICE_SECONDS", 0)) * time.Second
+	c.ExpiredRedirect = getEnv("TLDR_EXPIRED_REDIRECT_URL", "")
+	c.InvalidRedirect = getEnv("TLDR_INVALID_REDIRECT_URL", "")
+	c.WebhookSecret = getEnv("TLDR_WEBHOOK_SECRET", "")
+	c.SigningSecret = getEnv("TLDR_SIGNING_SECRET", "")
+	c.TrustedProxies = getEnvList("TLDR_TRUSTED_PROXIES", "")
+	c.ProxyHeader = getEnv("TLDR_PROXY_HEADER", fiber.HeaderXForwardedFor)
+	c.GeoIPDB = getEnv("TLDR_GEOIP_DB", "")
+	c.TLSCert = getEnv("TLDR_TLS_CERT", "")
+	c.TLSKey = getEnv("TLDR_TLS_KEY", "")
+	c.TLSMinVersion = getEnv("TLDR_TLS_MIN_VERSION", "1.2")
+	c.SecurityHeaders = getEnvBool("TLDR_SECURITY_HEADERS", true)
+	c.ReferrerPolicy = getEnv("TLDR_REFERRER_POLICY", "strict-origin-when-cross-origin")
+	c.HSTSMaxAge = getEnvInt("TLDR_HSTS_MAX_AGE", 31536000)
+
+	// The logger silently falls back to local time on an unknown zone, rather fail right away.
+	if _, err := time.LoadLocation(c.LogTimeZone); err != nil {
+		log.Fatalf("Invalid value for TLDR_LOG_TIMEZONE: %s", err.Error())
+	}
+	if c.DBConnectAttempts < 1 {
+		log.Fatalf("Invalid value for TLDR_DB_CONNECT_ATTEMPTS: must be at least 1")
+	}
+	if c.EnrichConcurrency < 1 {
+		log.Fatalf("Invalid value for TLDR_ENRICH_CONCURRENCY: must be at least 1")
+	}
+	if c.JSONStyle != jsonStylePascal && c.JSONStyle != jsonStyleCamel {
+		log.Fatalf("Invalid value for TLDR_JSON_STYLE: must be pascal or camel")
+	}
+	switch c.ShortenerMode {
+	case shortenerModeOff, shortenerModeReject, shortenerModeUnwrap:
+	default:
+		log.Fatalf("Invalid value for TLDR_SHORTENER_MODE: must be off, reject or unwrap")
+	}
+	if c.AliasConflict != aliasConflictReject && c.AliasConflict != aliasConflictUpdate {
+		log.Fatalf("Invalid value for TLDR_ALIAS_CONFLICT: must be reject or update")
+	}
+	if c.CollisionWarnPercent < 0 || c.CollisionWarnPercent > 100 {
+		log.Fatalf("Invalid value for TLDR_COLLISION_WARN_PERCENT: must be between 0 and 100")
+	}
+	if c.AbuseStrikes < 0 {
+		log.Fatalf("Invalid value for TLDR_ABUSE_STRIKES: must not be negative")
+	} else if c.AbuseStrikes > 0 && (c.AbuseWindow <= 0 || c.AbuseBan <= 0) {
+		log.Fatalf("TLDR_ABUSE_WINDOW_SECONDS and TLDR_ABUSE_BAN_SECONDS must be positive while TLDR_ABUSE_STRIKES is set")
+	}
+	if c.MaxAliasesPerUrl < 0 {
+		log.Fatalf("Invalid value for TLDR_MAX_ALIASES_PER_URL: must not be negative")
+	}
+	if c.CacheSize < 0 {
+		log.Fatalf("Invalid value for TLDR_CACHE_SIZE: must not be negative")
+	}
+	if c.MaxRedirects < 0 {
+		log.Fatalf("Invalid value for TLDR_MAX_REDIRECTS: must not be negative")
+	}
+	if c.Timeout < 0 {
+		log.Fatalf("Invalid value for TLDR_REQUEST_TIMEOUT: must not be negative")
+	}
+	if c.Maintenance < 0 {
+		log.Fatalf("Invalid value for TLDR_MAINTENANCE_INTERVAL_SECONDS: must not be negative")
+	}
+	if c.CompressMinLength < 0 {
+		log.Fatalf("Invalid value for TLDR_COMPRESS_MIN_LENGTH: must not be negative")
+	}
+	if c.DefaultTTL < 0 {
+		log.Fatalf("Invalid value for TLDR_DEFAULT_TTL_SECONDS: must not be negative")
+	}
+	if c.WebhookAttempts < 1 {
+		log.Fatalf("Invalid value for TLDR_WEBHOOK_ATTEMPTS: must be at least 1")
+	}
+	if c.WebhookBackoff < 0 {
+		log.Fatalf("Invalid value for TLDR_WEBHOOK_BACKOFF_MS: must not be negative")
+	}
+	if c.ExpiryNotice < 0 {
+		log.Fatalf("Invalid value for TLDR_EXPIRY_NOTICE_SECONDS: must not be negative")
+	} else if c.ExpiryNotice > 0 && c.WebhookURL == "" {
+		log.Fatalf("TLDR_EXPIRY_NOTICE_SECONDS needs TLDR_WEBHOOK_URL to send the notifications to")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		log.Fatalf("TLDR_TLS_CERT and TLDR_TLS_KEY have to be set together")
+	}
+	for _, file := range []string{c.TLSCert, c.TLSKey} {
+		if _, err := os.Stat(file); file != "" && err != nil {
+			log.Fatalf("Invalid TLS configuration: %s", err.Error())
+		}
+	}
+	if _, ok := tlsVersions[c.TLSMinVersion]; !ok {
+		log.Fatalf("Invalid value for TLDR_TLS_MIN_VERSION: must be 1.2 or 1.3")
+	}
+	if c.HSTSMaxAge < 0 {
+		log.Fatalf("Invalid value for TLDR_HSTS_MAX_AGE: must not be negative")
+	}
+	for key, target := range map[string]string{"TLDR_EXPIRED_REDIRECT_URL": c.ExpiredRedirect, "TLDR_INVALID_REDIRECT_URL": c.InvalidRedirect} {
+		if _, err := uri.ParseRequestURI(target); target != "" && err != nil {
+			log.Fatalf("Invalid value for %s: %s", key, err.Error())
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		if !validProxy(proxy) {
+			log.Fatalf("Invalid value for TLDR_TRUSTED_PROXIES: %s is neither an ip nor a cidr range", proxy)
+		}
+	}
+	return c
+}
+
+// TLSEnabled :: returns true if the api terminates tls itself (TLDR_TLS_CERT and TLDR_TLS_KEY).
+func (c config) TLSEnabled() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+// getEnv :: return the value of the environment variable or the fallback if it is unset/empty.
+func getEnv(key, fallback string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// getEnvInt :: same as 'getEnv' but for integers, exits if the value can't be parsed.
+func getEnvInt(key string, fallback int) int {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %s is not a number", key, value)
+	}
+	return i
+}
+
+// getEnvBool :: same as 'getEnv' but for booleans (1, t, true, 0, f, false, ...), exits if the value can't be parsed.
+func getEnvBool(key string, fallback bool) bool {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %s is not a boolean", key, value)
+	}
+	return b
+}
+
+// getEnvList :: split a comma separated environment variable into its (trimmed, non empty) items.
+func getEnvList(key, fallback string) []string {
+	var list []string
+
+	for _, item := range strings.Split(getEnv(key, fallback), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
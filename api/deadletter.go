@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// deadLetterListLimit :: the most dead letters 'DeadLetters' returns, newest first.
+const deadLetterListLimit = 100
+
+// DeadLetter :: a webhook delivery that failed every attempt, kept until it is delivered with 'RetryDeadLetter'.
+type DeadLetter struct {
+	ID    int64
+	About string
+	// Payload is the json body exactly as it was posted.
+	Payload  json.RawMessage
+	Error    string
+	Attempts int
+	FailedAt time.Time
+}
+
+// InsertDeadLetter :: keep a delivery that failed after attempts attempts, reason is the error of the last one.
+func (d database) InsertDeadLetter(about string, payload []byte, reason string, attempts int) error {
+	query := `INSERT INTO webhook_deadletter (about, payload, error, attempts, failed_at) VALUES (?, ?, ?, ?, ?)`
+
+	err := d.checkDb()
+	if err != nil {
+		return err
+	}
+
+	return withRetry(func() error {
+		_, err := d.db.ExecContext(d.context(), query, about, string(payload), reason, attempts, time.Now().Unix())
+		return err
+	})
+}
+
+// scanDeadLetter :: read a row of 'deadLetterColumns'.
+func scanDeadLetter(row scanner) (DeadLetter, error) {
+	var letter DeadLetter
+	var payload string
+	var failedAt int64
+
+	err := row.Scan(&letter.ID, &letter.About, &payload, &letter.Error, &letter.Attempts, &failedAt)
+	letter.Payload = json.RawMessage(payload)
+	letter.FailedAt = time.Unix(failedAt, 0).UTC()
+	return letter, err
+}
+
+const deadLetterColumns = `ID, about, payload, error, attempts, failed_at`
+
+// DeadLetters :: the latest dead letters, newest first, at most limit of them.
+func (d database) DeadLetters(limit int) ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	query := `SELECT ` + deadLetterColumns + ` FROM webhook_deadletter ORDER BY ID DESC LIMIT ?`
+
+	err := d.checkDb()
+	if err != nil {
+		return letters, err
+	}
+
+	rows, err := d.db.QueryContext(d.context(), query, limit)
+	if err != nil {
+		return letters, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return letters, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+// GetDeadLetter :: the dead letter with the id, false if there is none.
+func (d database) GetDeadLetter(id int64) (bool, DeadLetter, error) {
+	query := `SELECT ` + deadLetterColumns + ` FROM webhook_deadletter WHERE ID = ?`
+
+	err := d.checkDb()
+	if err != nil {
+		return false, DeadLetter{}, err
+	}
+
+	letter, err := scanDeadLetter(d.db.QueryRowContext(d.context(), query, id))
+	if err == sql.ErrNoRows {
+		return false, letter, nil
+	}
+	return err == nil, letter, err
+}
+
+// DeleteDeadLetter :: forget the dead letter, it got delivered or isn't wanted anymore.
+func (d database) DeleteDeadLetter(id int64) error {
+	err := d.checkDb()
+	if err != nil {
+		return err
+	}
+
+	return withRetry(func() error {
+		_, err := d.db.ExecContext(d.context(), `DELETE FROM webhook_deadletter WHERE ID = ?`, id)
+		return err
+	})
+}
+
+// FailedDeadLetter :: count another failed attempt of the dead letter, reason is its error.
+func (d database) FailedDeadLetter(id int64, reason string) error {
+	query := `UPDATE webhook_deadletter SET attempts = attempts + 1, error = ?, failed_at = ? WHERE ID = ?`
+
+	err := d.checkDb()
+	if err != nil {
+		return err
+	}
+
+	return withRetry(func() error {
+		_, err := d.db.ExecContext(d.context(), query, reason, time.Now().Unix(), id)
+		return err
+	})
+}
+
+// deadLetter :: keep a delivery that failed every attempt, failing to store it is only logged.
+func (w *webhook) deadLetter(about string, body []byte, reason error) {
+	if err := w.db.InsertDeadLetter(about, body, reason.Error(), w.attempts); err != nil {
+		log.Printf("ERROR: Could not store the failed webhook for %s: %s", about, err.Error())
+	}
+}
+
+// DeadLetters :: list the webhook deliveries that failed every attempt, the latest 100.
+func (h handler) DeadLetters(c *fiber.Ctx) error {
+	letters, err := h.dbFor(c).DeadLetters(deadLetterListLimit)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	return SendPayload(c, 200, "Ok", letters)
+}
+
+// RetryDeadLetter :: post a dead letter to the webhook again, once. It is deleted if the delivery works,
+// otherwise it stays with the new error.
+func (h handler) RetryDeadLetter(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return SendResponse(c, MakeResponse(400, "id must be a number", Url{}))
+	}
+	if h.webhook == nil {
+		return SendResponse(c, MakeErrorResponse(503, "WEBHOOK_DISABLED", "No webhook is configured, set TLDR_WEBHOOK_URL"))
+	}
+
+	db := h.dbFor(c)
+	found, letter, err := db.GetDeadLetter(id)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	} else if !found {
+		return SendResponse(c, MakeResponse(404, "No dead letter with this id", Url{}))
+	}
+
+	if err = h.webhook.post(letter.Payload); err != nil {
+		log.Printf("WARN: Retrying the webhook for %s failed: %s", letter.About, err.Error())
+		if err := db.FailedDeadLetter(id, err.Error()); err != nil {
+			log.Printf("ERROR: %s", err.Error())
+		}
+		return SendResponse(c, MakeErrorResponse(502, "WEBHOOK_FAILED", "Delivery failed: "+err.Error()))
+	}
+	if err = db.DeleteDeadLetter(id); err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	log.Printf("INFO: Delivered the dead letter %d for %s (request %s)", id, letter.About, RequestID(c))
+	return SendPayload(c, 200, "Delivered", letter)
+}
+
+// DeleteDeadLetter :: drop a dead letter without delivering it, e.g. one the receiver will never take.
+func (h handler) DeleteDeadLetter(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return SendResponse(c, MakeResponse(400, "id must be a number", Url{}))
+	}
+
+	db := h.dbFor(c)
+	found, letter, err := db.GetDeadLetter(id)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	} else if !found {
+		return SendResponse(c, MakeResponse(404, "No dead letter with this id", Url{}))
+	}
+	if err = db.DeleteDeadLetter(id); err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	log.Printf("INFO: Deleted the dead letter %d for %s (request %s)", id, letter.About, RequestID(c))
+	return SendPayload(c, 200, "Deleted", letter)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql"
+	"runtime"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// version :: the build version, set with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+// startedAt :: when the process started, for the uptime.
+var startedAt = time.Now()
+
+// debugVars :: runtime information for troubleshooting, e.g. goroutine or connection leaks.
+type debugVars struct {
+	Version    string
+	Uptime     string
+	Goroutines int
+	DB         sql.DBStats
+	// ShortGeneration tells how crowded the short space is, see 'generationCounter'.
+	ShortGeneration GenerationStats
+}
+
+// registerDebug :: serve /debug/vars, only called if TLDR_DEBUG is set.
+func registerDebug(router fiber.Router, db database) {
+	router.Get("/debug/vars", func(c *fiber.Ctx) error {
+		vars := debugVars{
+			Version:    version,
+			Uptime:     time.Since(startedAt).Round(time.Second).String(),
+			Goroutines: runtime.NumGoroutine(),
+			DB:         db.db.Stats(),
+
+			ShortGeneration: shortGenerations.Stats(),
+		}
+		return SendPayload(c, 200, "Ok", vars)
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// defaultDenylist :: words that never show up in a short, TLDR_DENYLIST_FILE adds more.
+var defaultDenylist = []string{
+	"fuck",
+	"shit",
+	"cunt",
+	"nazi",
+	"porn",
+}
+
+// loadDenylist :: read the words of a denylist file, one per line, empty lines and lines starting with '#' are skipped.
+func loadDenylist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words, scanner.Err()
+}
+
+// IsDeniedShort :: returns true if the short contains one of the denied words, case-insensitive.
+func IsDeniedShort(short string) bool {
+	short = strings.ToLower(short)
+	for _, list := range [][]string{defaultDenylist, conf.Denylist} {
+		for _, word := range list {
+			if strings.Contains(short, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordingStore :: a store without any shorts, it keeps the shorts that were looked up.
+type recordingStore struct {
+	lookups []string
+}
+
+func (s *recordingStore) ShortExists(short string) (bool, error) {
+	s.lookups = append(s.lookups, short)
+	return false, nil
+}
+
+// denylistFile :: write the words to a denylist file, returns its path.
+func denylistFile(t *testing.T, words ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	if err := os.WriteFile(path, []byte("# custom words\n"+strings.Join(words, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCreateUrlDeniedAlias(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"TLDR_DENYLIST_FILE": denylistFile(t, "Badword"), "TLDR_API_KEYS": "key"})
+	tests := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"url": "https://example.com", "alias": "shit"}`, 422, "DENIED_ALIAS"},
+		{`{"url": "https://example.com", "alias": "noShITatall"}`, 422, "DENIED_ALIAS"},
+		{`{"url": "https://example.com", "alias": "my-badword-link"}`, 422, "DENIED_ALIAS"},
+		{`{"url": "https://example.com", "alias": "BADWORD"}`, 422, "DENIED_ALIAS"},
+		{`{"url": "https://example.com", "alias": "fine", "namespace": "porn"}`, 422, "DENIED_NAMESPACE"},
+		{`{"url": "https://example.com", "alias": "fine"}`, 200, ""},
+		// The comment line of the file isn't a word.
+		{`{"url": "https://example.com", "alias": "custom"}`, 200, ""},
+	}
+	for _, test := range tests {
+		status, resp := send(t, app, "POST", "/api/", test.body)
+		if status != test.status || resp.Code != test.code {
+			t.Errorf("%s: %d %s, want %d %s", test.body, status, resp.Code, test.status, test.code)
+		}
+	}
+	if status, resp := send(t, app, "PUT", "/api/alias/xbadwordx", `{"url": "https://example.com"}`, "X-API-Key", "key"); status != 422 || resp.Code != "DENIED_ALIAS" {
+		t.Errorf("put of a denied alias: %d %s, want 422 DENIED_ALIAS", status, resp.Code)
+	}
+}
+
+func TestPrepareNewUrlSkipsDenied(t *testing.T) {
+	// Deny a word out of the first short the seed generates, the second one has to be used instead.
+	fixedSeed(t)
+	denied := CreateRandomString(shortLength)
+	word := strings.ToLower(denied[4:8])
+	newTestApp(t, map[string]string{"TLDR_DENYLIST_FILE": denylistFile(t, word)})
+	fixedSeed(t)
+
+	store := &recordingStore{}
+	url, err := prepareNewUrl(store, "https://example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url.Short == denied || strings.Contains(strings.ToLower(url.Short), word) {
+		t.Errorf("generated %s with the denied word %s", url.Short, word)
+	}
+	for _, short := range store.lookups {
+		if short == denied {
+			t.Errorf("the denied short %s was looked up", short)
+		}
+	}
+	if len(store.lookups) != 1 {
+		t.Errorf("%d lookups, want the one of the second short", len(store.lookups))
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	uri "net/url"
+)
+
+const (
+	enrichTimeout  = 3 * time.Second
+	maxEnrichBytes = 512 * 1024
+)
+
+var (
+	// enrichClient :: the http client for all outbound requests that enrich or check a url (favicon, reputation, ...),
+	// the short timeout makes sure a slow target can't hold anything up for long. 'limitEnrichment' caps its concurrency.
+	enrichClient = &http.Client{Timeout: enrichTimeout, CheckRedirect: limitRedirects, Transport: publicTransport}
+
+	// publicTransport :: the transport of the enrich client, it only connects to public addresses (see 'refusePrivate').
+	publicTransport = newPublicTransport()
+
+	// ErrPrivateAddress :: the host resolved to an address the server must not request on behalf of a client.
+	ErrPrivateAddress = errors.New("the host resolves to a private, loopback or link-local address")
+
+	// privateNetworks :: the networks 'refusePrivate' refuses, besides loopback, link-local and unspecified addresses.
+	privateNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+	// ErrTooManyRedirects :: the target redirected more often than TLDR_MAX_REDIRECTS allows, e.g. in a loop.
+	ErrTooManyRedirects = errors.New("too many redirects")
+
+	linkTagRegex = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	relRegex     = regexp.MustCompile(`(?is)\brel\s*=\s*["']?([^"'>]+)`)
+	hrefRegex    = regexp.MustCompile(`(?is)\bhref\s*=\s*["']?([^"'\s>]+)`)
+)
+
+// parseNetworks :: parse the cidrs, they are constants so a broken one is a bug.
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// isPrivateIP :: true for loopback, link-local (cloud metadata lives there), unspecified and private (RFC 1918, ULA, CGNAT) addresses.
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// refusePrivate :: dialer hook that runs after the name got resolved, so a public name of a private address is refused as well.
+// TLDR_ALLOW_PRIVATE_TARGETS turns it off, e.g. for an instance that shortens intranet links.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	if conf.AllowPrivateTargets {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return fmt.Errorf("%s: %w", host, ErrPrivateAddress)
+	}
+	return nil
+}
+
+// newPublicTransport :: the default transport, with a dialer that refuses private addresses.
+func newPublicTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: refusePrivate}
+	transport.DialContext = dialer.DialContext
+	// A proxy would be dialed instead of the target, the check has to see the target itself.
+	transport.Proxy = nil
+	return transport
+}
+
+// ResolveFavicon :: find the favicon of the page, either from a <link rel="icon"> tag or the hosts /favicon.ico.
+func ResolveFavicon(target string) (string, error) {
+	page, err := uri.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := enrichClient.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEnrichBytes))
+	if err != nil {
+		return "", err
+	}
+	if icon := findIconLink(string(body)); icon != "" {
+		// The href is usually relative to the page, resolve it against the url we ended up at.
+		if ref, err := uri.Parse(icon); err == nil {
+			return resp.Request.URL.ResolveReference(ref).String(), nil
+		}
+	}
+
+	// No <link> tag, fall back to the well-known location.
+	fallback := fmt.Sprintf("%s://%s/favicon.ico", page.Scheme, page.Host)
+	head, err := enrichClient.Head(fallback)
+	if err != nil {
+		return "", err
+	}
+	head.Body.Close()
+	if head.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("no favicon found for %s", target)
+	}
+	return fallback, nil
+}
+
+// limitRedirects :: the redirect policy of the enrich client, follows at most TLDR_MAX_REDIRECTS redirects.
+func limitRedirects(req *http.Request, via []*http.Request) error {
+	if len(via) > conf.MaxRedirects {
+		return fmt.Errorf("%w (more than %d)", ErrTooManyRedirects, conf.MaxRedirects)
+	}
+	return nil
+}
+
+// CheckTarget :: request the url (following redirects) and return the final status and url, e.g. to find dead links.
+// HEAD is tried first, servers that don't support it get a GET (the body isn't read).
+func CheckTarget(ctx context.Context, target string) (int, string, error) {
+	resp, err := requestWithContext(ctx, http.MethodHead, target)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = requestWithContext(ctx, http.MethodGet, target)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, resp.Request.URL.String(), nil
+}
+
+// requestWithContext :: send a bodyless request with the enrich client that gets cancelled with ctx.
+func requestWithContext(ctx context.Context, method, target string) (*http.Response, error) {
+	return sendWith(ctx, enrichClient, method, target)
+}
+
+// sendWith :: send a bodyless request with the client that gets cancelled with ctx.
+func sendWith(ctx context.Context, client *http.Client, method, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// findIconLink :: returns the href of the first <link> tag with an icon rel, empty if there is none.
+func findIconLink(html string) string {
+	for _, tag := range linkTagRegex.FindAllString(html, -1) {
+		rel := relRegex.FindStringSubmatch(tag)
+		if rel == nil || !strings.Contains(strings.ToLower(rel[1]), "icon") {
+			continue
+		}
+		if href := hrefRegex.FindStringSubmatch(tag); href != nil {
+			return href[1]
+		}
+	}
+	return ""
+}
+
+// EnrichFavicon :: resolve the favicon of the url in the background and store it, failures are only logged.
+func (d database) EnrichFavicon(url Url) {
+	go func() {
+		favicon, err := ResolveFavicon(url.Url)
+		if err != nil {
+			log.Printf("WARN: Could not resolve favicon of '%s': %s", url.Short, err.Error())
+			return
+		}
+		if err = d.UpdateFavicon(url.Short, favicon); err != nil {
+			log.Printf("ERROR: %s", err.Error())
+		}
+	}()
+}
+
+// UpdateFavicon :: store the resolved favicon url of the short.
+func (d database) UpdateFavicon(short, favicon string) error {
+	query := `UPDATE url SET favicon_url = ? WHERE ` + shortEquals()
+
+	err := d.checkDb()
+	if err != nil {
+		return err
+	}
+
+	err = withRetry(func() error {
+		_, err := d.db.ExecContext(d.context(), query, favicon, short)
+		return err
+	})
+	cachedUrls.Remove(short)
+	return err
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errorHandler :: turns errors returned by handlers/middleware (and recovered panics) into the usual json response.
+// Unexpected errors are logged but never sent to the client.
+func errorHandler(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return SendResponse(c, MakeResponse(fiberErr.Code, fiberErr.Message, Url{}))
+	}
+
+	log.Printf("ERROR: Request %s failed: %s", RequestID(c), err.Error())
+	return SendResponse(c, MakeResponse(500, "internal error", Url{}))
+}
+
+// logStackTrace :: log the stack trace of a recovered panic.
+func logStackTrace(c *fiber.Ctx, e interface{}) {
+	log.Printf("ERROR: Request %s recovered from panic: %v\n%s", RequestID(c), e, debug.Stack())
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// eventBuffer is how many events a subscriber may fall behind before it misses some.
+	eventBuffer    = 64
+	eventKeepAlive = 15 * time.Second
+)
+
+// linkEvent :: a change to the urls, sent to the subscribers of 'GET /api/events'.
+type linkEvent struct {
+	Event string
+	Short string `json:",omitempty"`
+	Url   string `json:",omitempty"`
+	// Shorts are the deleted shorts of the owner, Count how many there are.
+	Shorts []string `json:",omitempty"`
+	Count  int64    `json:",omitempty"`
+	At     time.Time
+	// owner limits who gets to see the event (see 'listOwner'), everybody overrides it for events that affect all owners.
+	owner     string
+	everybody bool
+}
+
+// eventBroker :: fans the link events out to all subscribers.
+// Publishing never blocks on a slow subscriber, publishing to a nil broker does nothing.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan linkEvent]struct{}
+}
+
+// newEventBroker :: create a broker without subscribers.
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: make(map[chan linkEvent]struct{})}
+}
+
+// Subscribe :: a channel that receives every event from now on, hand it back with 'Unsubscribe'.
+func (b *eventBroker) Subscribe() chan linkEvent {
+	ch := make(chan linkEvent, eventBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe :: stop sending events to the channel.
+func (b *eventBroker) Unsubscribe(ch chan linkEvent) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+// Publish :: send the event to every subscriber, those whose buffer is full miss it.
+func (b *eventBroker) Publish(event linkEvent) {
+	if b == nil {
+		return
+	}
+	event.At = time.Now().UTC()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("WARN: Event subscriber is too slow, dropped the '%s' event of '%s'", event.Event, event.Short)
+		}
+	}
+}
+
+// Created :: publish a newly created url.
+func (b *eventBroker) Created(url Url) {
+	b.Publish(linkEvent{Event: "created", Short: url.Short, Url: url.Url, owner: url.Owner})
+}
+
+// Deleted :: publish a (bulk) delete, one event per owner so every subscriber only learns about its own shorts.
+func (b *eventBroker) Deleted(deleted deletedUrls) {
+	for owner, shorts := range deleted {
+		b.Publish(linkEvent{Event: "deleted", Shorts: shorts, Count: int64(len(shorts)), owner: owner})
+	}
+}
+
+// DeletedAll :: publish that every url was deleted, a delete without shorts that everybody sees.
+func (b *eventBroker) DeletedAll(count int64) {
+	if count == 0 {
+		return
+	}
+	b.Publish(linkEvent{Event: "deleted", Count: count, everybody: true})
+}
+
+// Events :: stream created and deleted urls as server-sent events, for live dashboards.
+// Every event is a json object like '{"Event": "created", "Short": ..., "Url": ..., "At": ...}' (styled like every response),
+// a comment is sent every few seconds to keep the connection (and proxies) alive.
+// Keys that aren't admin keys only see the urls they created or deleted themselves.
+func (h handler) Events(c *fiber.Ctx) error {
+	owner := listOwner(c)
+	requestID := RequestID(c)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	// nginx buffers responses by default, which holds the events back.
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		events := h.events.Subscribe()
+		defer h.events.Unsubscribe(events)
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+
+		fmt.Fprint(w, ": connected\n\n")
+		for {
+			if err := w.Flush(); err != nil {
+				// The client went away, that's the usual way for a stream to end.
+				log.Printf("INFO: Event stream %s closed: %s", requestID, err.Error())
+				return
+			}
+			select {
+			case event := <-events:
+				if owner != "" && event.owner != owner && !event.everybody {
+					continue
+				}
+				body, err := styledJSON(event)
+				if err != nil {
+					log.Printf("ERROR: %s", err.Error())
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, body)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+		}
+	})
+	return nil
+}
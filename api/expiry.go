@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// expiryNoticeInterval :: how often 'scheduleExpiryNotices' looks for urls that expire soon.
+	expiryNoticeInterval = time.Minute
+	// expiryNoticeBatch :: at most this many urls go into one notification.
+	expiryNoticeBatch = 100
+)
+
+// expiryEvent :: the json body posted to the webhook for urls that expire within TLDR_EXPIRY_NOTICE_SECONDS.
+type expiryEvent struct {
+	Event string        `json:"event"`
+	Urls  []expiringUrl `json:"urls"`
+}
+
+type expiringUrl struct {
+	Url       string    `json:"url"`
+	Short     string    `json:"short"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ExpiringUrls :: active urls that expire between now and before and weren't notified about yet, soonest first.
+func (d database) ExpiringUrls(before time.Time, limit int) ([]Url, error) {
+	query := `SELECT ` + urlColumns + ` FROM url WHERE expiry_notified = 0 AND valid = ? AND expires_at > ? AND expires_at <= ?
+			  ORDER BY expires_at, ID LIMIT ?`
+	var urls []Url
+
+	err := d.checkDb()
+	if err != nil {
+		return urls, err
+	}
+
+	rows, err := d.db.QueryContext(d.context(), query, StatusActive, time.Now().Unix(), before.Unix(), limit)
+	if err != nil {
+		return urls, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		url, err := scanUrl(rows)
+		if err != nil {
+			return urls, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, rows.Err()
+}
+
+// MarkExpiryNotified :: remember that the urls were announced as expiring, so they aren't announced again.
+func (d database) MarkExpiryNotified(shorts []string) error {
+	err := d.checkDb()
+	if err != nil {
+		return err
+	}
+	if len(shorts) == 0 {
+		return nil
+	}
+
+	condition, args := shortsIn(shorts)
+	return withRetry(func() error {
+		_, err := d.db.ExecContext(d.context(), `UPDATE url SET expiry_notified = 1 WHERE `+condition, args...)
+		return err
+	})
+}
+
+// Expiring :: post the urls that are about to expire, unlike 'Created' this waits for the delivery and returns its error.
+func (w *webhook) Expiring(urls []Url) error {
+	event := expiryEvent{Event: "expiring"}
+	for _, url := range urls {
+		event.Urls = append(event.Urls, expiringUrl{Url: url.Url, Short: url.Short, ExpiresAt: url.ExpiresAt.UTC()})
+	}
+	return w.deliver("expiring urls", event)
+}
+
+// notifyExpiring :: announce every url that expires within the window, in batches.
+// Urls are only marked once the webhook took them, a failed delivery is retried on the next run.
+func notifyExpiring(db database, w *webhook, window time.Duration) error {
+	for {
+		urls, err := db.ExpiringUrls(time.Now().Add(window), expiryNoticeBatch)
+		if err != nil || len(urls) == 0 {
+			return err
+		}
+		if err = w.Expiring(urls); err != nil {
+			return err
+		}
+		shorts := make([]string, len(urls))
+		for i, url := range urls {
+			shorts[i] = url.Short
+		}
+		if err = db.MarkExpiryNotified(shorts); err != nil {
+			return err
+		}
+		log.Printf("INFO: Announced %d expiring urls", len(urls))
+		if len(urls) < expiryNoticeBatch {
+			return nil
+		}
+	}
+}
+
+// scheduleExpiryNotices :: run 'notifyExpiring' right away and then every 'expiryNoticeInterval' in the background.
+func scheduleExpiryNotices(db database, w *webhook, window time.Duration) {
+	go func() {
+		ticker := time.NewTicker(expiryNoticeInterval)
+		defer ticker.Stop()
+		for {
+			if err := notifyExpiring(db, w, window); err != nil {
+				log.Printf("ERROR: Expiry notification failed: %s", err.Error())
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportFlushRows :: the export is flushed to the client every that many rows.
+const exportFlushRows = 500
+
+// Export :: stream every url as newline delimited json, one 'Url' per line and oldest first.
+// The rows are written while they are read, memory stays flat no matter how big the database is.
+// The status is sent before the first row, an error midway ends the stream early (and gets logged).
+func (h handler) Export(c *fiber.Ctx) error {
+	if format := c.Query("format", "ndjson"); format != "ndjson" {
+		data := MakeResponse(400, "format must be ndjson", Url{})
+		return SendResponse(c, data)
+	}
+
+	// The writer runs after the handler returned, so it can't touch 'c' (or the request deadline, exports take long).
+	base := shortUrlBase(c)
+	hideClicks := !showClicks(c)
+	owner := listOwner(c)
+	requestID := RequestID(c)
+	db := h.db
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="tldr-export.ndjson"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		rows := 0
+		err := db.EachUrl(UrlFilter{Owner: owner, Asc: true}, func(url Url) error {
+			url.ShortUrl = base + SignShort(url.Short)
+			url.hideClicks = hideClicks
+			line, err := styledJSON(url)
+			if err != nil {
+				return err
+			}
+			if _, err = w.Write(append(line, '\n')); err != nil {
+				return err
+			}
+			rows++
+			if rows%exportFlushRows == 0 {
+				return w.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("ERROR: Export %s stopped after %d rows: %s", requestID, rows, err.Error())
+			return
+		}
+		w.Flush()
+	})
+	return nil
+}
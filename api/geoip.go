@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// unknownCountry :: the country of clicks that couldn't be located (private ips, missing in the database, geoip disabled).
+const unknownCountry = "unknown"
+
+// geoLocator :: resolves ips to countries with a MaxMind GeoLite2/GeoIP2 country (or city) database.
+// A nil locator is valid, it doesn't know any country, that's what you get when TLDR_GEOIP_DB is unset.
+type geoLocator struct {
+	reader *geoip2.Reader
+}
+
+// newGeoLocator :: open the database at the path, an empty path disables geolocation.
+func newGeoLocator(path string) (*geoLocator, error) {
+	if path == "" {
+		return nil, nil
+	}
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &geoLocator{reader: reader}, nil
+}
+
+// Country :: returns the ISO country code of the ip, empty if it is unknown.
+func (g *geoLocator) Country(ip string) string {
+	if g == nil {
+		return ""
+	}
+	// Private and loopback ranges aren't in the database, they come back without a country.
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	record, err := g.reader.Country(parsed)
+	if err != nil {
+		return ""
+	}
+	return record.Country.IsoCode
+}
@@ -3,8 +3,9 @@ module github.com/mikeunge/tldr-api
 go 1.16
 
 require (
-	github.com/gofiber/fiber/v2 v2.10.0
+	github.com/gofiber/fiber/v2 v2.25.0
 	github.com/mattn/go-sqlite3 v1.14.7
-	github.com/valyala/fasthttp v1.25.0 // indirect
+	github.com/oschwald/geoip2-golang v1.5.0
+	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
 	golang.org/x/sys v0.0.0-20210521090106-6ca3eb03dfc2 // indirect
 )
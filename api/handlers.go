@@ -0,0 +1,1292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	uri "net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxResolveShorts :: upper limit of shorts per batch lookup.
+const maxResolveShorts = 100
+
+// maxDeleteShorts :: the most shorts a single 'DeleteUrls' request may delete.
+const maxDeleteShorts = 500
+
+// handler :: the http handlers, holds everything they need to serve a request.
+type handler struct {
+	db         database
+	clicks     *clickLogger
+	reputation ReputationChecker
+	webhook    *webhook
+	links      *linkCounter
+	events     *eventBroker
+	abuse      *abuseGuard
+}
+
+// registerRoutes :: register all api routes on the router, the catch-all lookup has to stay last.
+func registerRoutes(router fiber.Router, h handler) {
+	router.Get("/", requireListAuth, h.ListUrls)
+	router.Post("/", h.abuse.CountStrikes, textFormat, requireJSON, h.CreateUrl)
+	router.Delete("/", requireAuth, requireAdmin, h.DeleteAll)
+	router.Post("/resolve", requireJSON, h.ResolveShorts)
+	router.Post("/stats/batch", requireClicksAuth, requireJSON, h.BatchStats)
+	router.Post("/reserve", requireJSON, h.ReserveAlias)
+	router.Post("/delete", requireAuth, requireJSON, h.DeleteUrls)
+	router.Post("/purge", requireAuth, h.Purge)
+	router.Get("/export", requireListAuth, h.Export)
+	router.Get("/random", requireListAuth, h.RandomUrl)
+	router.Get("/count", requireListAuth, h.CountUrls)
+	router.Get("/events", requireAuth, h.Events)
+	router.Post("/maintenance", requireAuth, h.Maintenance)
+	router.Get("/webhooks/deadletter", requireAuth, requireAdmin, h.DeadLetters)
+	router.Post("/webhooks/deadletter/:id/retry", requireAuth, requireAdmin, h.RetryDeadLetter)
+	router.Delete("/webhooks/deadletter/:id", requireAuth, requireAdmin, h.DeleteDeadLetter)
+	router.Get("/:short/stats", requireClicksAuth, h.GetStats)
+	router.Get("/:short/exists", h.ShortExists)
+	router.Get("/:short/analytics.csv", requireAuth, h.ClickLogCSV)
+	router.Get("/:short/barcode", h.Barcode)
+	router.Get("/:short/redirect-chain", requireAuth, h.CheckTarget)
+	// Without its own route HEAD would fall through to 'GetUrl' and count as a use.
+	router.Head("/:short", h.ShortExists)
+	router.Put("/alias/:alias", requireAuth, h.abuse.CountStrikes, requireJSON, h.PutAlias)
+	router.Put("/:short", requireAuth, h.abuse.CountStrikes, requireJSON, h.UpdateUrl)
+	router.Patch("/:short", requireAuth, requireJSON, h.PatchUrl)
+	router.Get("/*", h.GetUrl)
+}
+
+// requireJSON :: middleware that rejects requests which don't send a json body.
+func requireJSON(c *fiber.Ctx) error {
+	contentType := strings.ToLower(string(c.Request().Header.ContentType()))
+	if !strings.HasPrefix(contentType, fiber.MIMEApplicationJSON) {
+		data := MakeResponse(415, "Content-Type must be application/json", Url{})
+		return SendResponse(c, data)
+	}
+	return c.Next()
+}
+
+// textFormatKey :: the local that makes 'SendResponse' answer with text, it holds the requested format.
+const textFormatKey = "format_text"
+
+// textFormats :: the '?format=' values 'textFormat' understands, with the content type of each.
+var textFormats = map[string]string{
+	"text":     "text/plain; charset=utf-8",
+	"markdown": "text/markdown; charset=utf-8",
+	"html":     "text/html; charset=utf-8",
+}
+
+// textFormat :: middleware that switches the response to text for '?format=text', handy in shell pipelines.
+// '?format=markdown' and '?format=html' answer with a link that is ready to be pasted into docs.
+func textFormat(c *fiber.Ctx) error {
+	if format := c.Query("format"); textFormats[format] != "" {
+		c.Locals(textFormatKey, format)
+	}
+	return c.Next()
+}
+
+// shortSnippet :: the short url in the text format, a link for markdown and html.
+func shortSnippet(format, shortUrl string) string {
+	switch format {
+	case "markdown":
+		return fmt.Sprintf("[%s](%s)", shortUrl, shortUrl)
+	case "html":
+		escaped := html.EscapeString(shortUrl)
+		return fmt.Sprintf(`<a href="%s">%s</a>`, escaped, escaped)
+	}
+	return shortUrl
+}
+
+// invalidShortResponse :: the response for shorts that can't exist, see 'IsValidShort'.
+func invalidShortResponse(short string) Data {
+	msg := fmt.Sprintf("'%s' is not a valid short.", short)
+	return MakeErrorResponse(400, "INVALID_SHORT", msg)
+}
+
+// originalUrl :: the submitted url to keep next to its normalized target, empty if normalizing didn't change it
+// or TLDR_KEEP_ORIGINAL_URL is off.
+func originalUrl(submitted, target string) string {
+	if !conf.KeepOriginalUrl || submitted == target {
+		return ""
+	}
+	return submitted
+}
+
+// normalizeTarget :: clean up and validate a url that should be redirected to, prefixes https:// if it has no http* scheme.
+// Returns false and the response to send if the url can't be used.
+func normalizeTarget(target string) (string, Data, bool) {
+	// Copy & paste tends to add whitespace/newlines around the url, get rid of them.
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", MakeResponse(422, "URL must not be empty", Url{}), false
+	}
+	if HasInvalidUrlChars(target) {
+		return "", MakeResponse(422, "URL must not contain spaces or control characters", Url{}), false
+	}
+
+	// Only http(s) urls get shortened, prefixing https:// to 'javascript:alert(1)' or 'data:...' just hides them.
+	if scheme := UrlScheme(target); scheme != "" && scheme != "http" && scheme != "https" {
+		msg := fmt.Sprintf("URL scheme '%s' is not supported, only http and https are", scheme)
+		return "", MakeErrorResponse(422, "UNSUPPORTED_SCHEME", msg), false
+	}
+
+	// Make sure that the provided url is an actuall url that can get redirected to (http|https).
+	https, err := IsValidHttpsUrl(target)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+	}
+	http, err := IsValidHttpUrl(target)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+	}
+	if http && conf.RequireHTTPS {
+		return "", MakeErrorResponse(422, "HTTPS_REQUIRED", "URL must use https, plain http targets are not allowed"), false
+	}
+	if !https && !http {
+		log.Printf("WARN: URL (%s) does not have a http* prefix, adding https:// to it", target)
+		target = "https://" + target
+	}
+	// Check if it's parseable.
+	_, err = uri.ParseRequestURI(target)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return "", MakeResponse(500, err.Error(), Url{}), false
+	}
+	return target, Data{}, true
+}
+
+// checkReputation :: make sure the target isn't a known bad url, returns the response to send if it is (or can't be checked).
+func (h handler) checkReputation(c *fiber.Ctx, target string) (Data, bool) {
+	safe, reason, err := h.reputation.Check(c.UserContext(), target)
+	if err != nil {
+		log.Printf("ERROR: Reputation check of '%s' failed: %s", target, err.Error())
+		if !conf.ReputationFailOpen {
+			return MakeErrorResponse(503, "REPUTATION_UNAVAILABLE", "The URL could not be checked, try again later"), false
+		}
+	} else if !safe {
+		log.Printf("WARN: Rejected unsafe URL (%s): %s", target, reason)
+		return MakeErrorResponse(403, "UNSAFE_URL", fmt.Sprintf("URL is not allowed, it is %s", reason)), false
+	}
+	return Data{}, true
+}
+
+// parseTimeParam :: parse a time from a query parameter, either RFC 3339 or unix seconds.
+func parseTimeParam(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// checkNamespace :: validate the namespace of a new short, returns it in its canonical form or the response to send.
+func checkNamespace(namespace string) (string, Data, bool) {
+	if namespace == "" {
+		return "", Data{}, true
+	}
+	if !IsValidCode(namespace) {
+		msg := fmt.Sprintf("Namespace may only contain letters, digits, '-' and '_' and be at most %d characters long.", maxShortLength)
+		return "", MakeErrorResponse(422, "INVALID_NAMESPACE", msg), false
+	}
+	if IsDeniedShort(namespace) {
+		return "", MakeErrorResponse(422, "DENIED_NAMESPACE", "Namespace contains a word that isn't allowed."), false
+	}
+	return canonicalShort(namespace), Data{}, true
+}
+
+// checkAlias :: make sure the alias can be claimed in the namespace by the holder of the reservation token,
+// returns the short it becomes or the response to send.
+func (h handler) checkAlias(c *fiber.Ctx, alias, namespace, reservation string) (string, Data, bool) {
+	if !IsValidCode(alias) {
+		msg := fmt.Sprintf("Alias may only contain letters, digits, '-' and '_' and be at most %d characters long.", maxShortLength)
+		return "", MakeErrorResponse(422, "INVALID_ALIAS", msg), false
+	}
+	if IsDeniedShort(alias) {
+		return "", MakeErrorResponse(422, "DENIED_ALIAS", "Alias contains a word that isn't allowed."), false
+	}
+	if IsReservedShort(alias) {
+		msg := fmt.Sprintf("Alias '%s' is reserved.", alias)
+		return "", MakeErrorResponse(409, "RESERVED_ALIAS", msg), false
+	}
+	short := Namespaced(namespace, canonicalShort(alias))
+	found, err := h.dbFor(c).ShortExists(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return "", MakeResponse(500, err.Error(), Url{}), false
+	} else if found {
+		msg := fmt.Sprintf("Alias '%s' is already taken.", short)
+		return "", MakeErrorResponse(409, "ALIAS_TAKEN", msg), false
+	}
+	if reservations.Blocks(short, reservation) {
+		return "", aliasHeldResponse(short), false
+	}
+	return short, Data{}, true
+}
+
+// checkReachable :: with TLDR_VERIFY_ON_CREATE make sure the target answers (after redirects) with a 2xx or 3xx,
+// returns the response to send if it doesn't. Without it every target passes.
+func checkReachable(c *fiber.Ctx, target string) (Data, bool) {
+	if !conf.VerifyOnCreate {
+		return Data{}, true
+	}
+	status, _, err := CheckTarget(c.UserContext(), target)
+	if err != nil {
+		log.Printf("WARN: Rejected unreachable URL (%s): %s", target, err.Error())
+		return MakeErrorResponse(422, "UNREACHABLE_TARGET", fmt.Sprintf("URL could not be reached: %s", err.Error())), false
+	} else if status >= 400 {
+		log.Printf("WARN: Rejected unreachable URL (%s): status %d", target, status)
+		return MakeErrorResponse(422, "UNREACHABLE_TARGET", fmt.Sprintf("URL answered with status %d", status)), false
+	}
+	return Data{}, true
+}
+
+// listFilter :: the filter built from the query parameters of a listing (see 'ListUrls'), narrowed down to the urls of the key.
+// Returns the response to send if a parameter is invalid.
+func listFilter(c *fiber.Ctx) (UrlFilter, Data, bool) {
+	var filter UrlFilter
+	filter.Tag = strings.ToLower(strings.TrimSpace(c.Query("tag")))
+	filter.Namespace = canonicalShort(c.Query("namespace"))
+	filter.Search = strings.TrimSpace(c.Query("q"))
+	filter.Owner = listOwner(c)
+	if c.Query("valid") != "" {
+		valid, err := strconv.ParseBool(c.Query("valid"))
+		if err != nil {
+			return filter, MakeResponse(400, "valid must be true or false", Url{}), false
+		}
+		filter.Valid = &valid
+	}
+	if c.Query("status") != "" {
+		status, err := ParseStatus(c.Query("status"))
+		if err != nil {
+			return filter, MakeResponse(400, err.Error(), Url{}), false
+		}
+		filter.Status = &status
+	}
+	for param, bound := range map[string]**time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		if c.Query(param) == "" {
+			continue
+		}
+		t, err := parseTimeParam(c.Query(param))
+		if err != nil {
+			msg := fmt.Sprintf("%s must be an RFC 3339 time or unix seconds", param)
+			return filter, MakeResponse(400, msg, Url{}), false
+		}
+		*bound = &t
+	}
+	return filter, Data{}, true
+}
+
+// ListUrls :: base /api/ route, returns ALL the available/registered routes/urls.
+// Filter by tag with '?tag=' and by the valid flag with '?valid=true|false', search shorts and notes with '?q='.
+// '?created_after=' and '?created_before=' (RFC 3339 or unix seconds, both inclusive) limit the creation time.
+// Sorted by '?sort=created_at|clicks|short' and '?order=asc|desc', newest first by default.
+func (h handler) ListUrls(c *fiber.Ctx) error {
+	filter, resp, ok := listFilter(c)
+	if !ok {
+		return SendResponse(c, resp)
+	}
+	filter.Sort = c.Query("sort", "created_at")
+	if _, ok := sortColumns[filter.Sort]; !ok {
+		data := MakeResponse(400, "sort must be one of created_at, clicks or short", Url{})
+		return SendResponse(c, data)
+	}
+	switch c.Query("order", "desc") {
+	case "asc":
+		filter.Asc = true
+	case "desc":
+	default:
+		data := MakeResponse(400, "order must be asc or desc", Url{})
+		return SendResponse(c, data)
+	}
+
+	urlMap, err := h.dbFor(c).GetAllUrls(filter)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		data := MakeResponse(500, err.Error(), Url{})
+		return SendResponse(c, data)
+	}
+
+	// Filter the db response and create a payload to send back.
+	var data []Data
+	for i := 0; i < len(urlMap); i++ {
+		var resp Data
+
+		url := urlMap[i]
+		url.ShortUrl = ShortUrl(c, url.Short)
+		url.hideClicks = !showClicks(c)
+		// Don't leak the target of password protected urls.
+		if IsProtected(url) {
+			url.Url, url.OriginalUrl = "", ""
+		}
+		if IsValid(urlMap[i]) {
+			resp = MakeResponse(200, "Ok", url)
+		} else {
+			resp = MakeResponse(422, "URL is not valid", url)
+		}
+		data = append(data, resp)
+	}
+
+	return sendJSON(c, 200, data)
+}
+
+// CountUrls :: the number of urls 'ListUrls' would return with the same filters, e.g. for a pager.
+// Counts with a single query instead of loading the urls.
+func (h handler) CountUrls(c *fiber.Ctx) error {
+	filter, data, ok := listFilter(c)
+	if !ok {
+		return SendResponse(c, data)
+	}
+	count, err := h.dbFor(c).CountUrls(filter)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+
+	type countResult struct {
+		Count int64
+	}
+	return SendPayload(c, 200, "Ok", countResult{Count: count})
+}
+
+// CreateUrl :: create new shorts, send a payload containing the url you want to be shortened.
+// An optional 'alias' claims a custom short instead of a random one, reserved words can't be claimed.
+// An alias held with 'POST /api/reserve' needs the token of the reservation as 'reservation'.
+// Setting 'one_time' creates a short that resolves exactly once, 'max_clicks' one that resolves at most that often.
+// A 'password' is required to resolve the short.
+// 'tags' are free form labels to organize urls, the list can be filtered by them.
+// With 'favicon' the favicon of the target gets resolved in the background (best-effort).
+// 'active_from' (RFC 3339) schedules the short, it doesn't resolve before that time.
+// 'expires_at' (RFC 3339) or 'ttl_seconds' make the short stop resolving, without either TLDR_DEFAULT_TTL_SECONDS
+// applies (if configured), 'permanent' opts out of it.
+// 'strip_tracking' removes tracking parameters (utm_*, fbclid, gclid) from the url before it's stored.
+// With 'dry_run' (or '?dry_run=true') the url is validated and normalized but not stored, the response shows the
+// short that would have been created, a generated short isn't reserved though.
+// Clients can send an 'Idempotency-Key' header, retrying with the same key returns the
+// previously created short instead of creating a new one, 'Created' in the response tells both cases apart.
+// Keys are per api key owner (or client ip), a retry while the first request is still running gets a 409.
+// Post body example:
+//
+//	{
+//		"url": "example-domain.com",
+//		"alias": "example",
+//		"one_time": false,
+//		"max_clicks": 100,
+//		"password": "secret",
+//		"tags": ["work", "docs"],
+//		"note": "Q3 campaign landing page",
+//		"favicon": true,
+//		"active_from": "2021-06-01T08:00:00Z",
+//		"ttl_seconds": 86400,
+//		"strip_tracking": true
+//	}
+func (h handler) CreateUrl(c *fiber.Ctx) error {
+	var err error
+	var data Data
+	type urlPost struct {
+		Url           string     `json:"url"`
+		Alias         string     `json:"alias"`
+		Namespace     string     `json:"namespace"`
+		OneTime       bool       `json:"one_time"`
+		MaxClicks     int        `json:"max_clicks"`
+		Password      string     `json:"password"`
+		Tags          []string   `json:"tags"`
+		Favicon       bool       `json:"favicon"`
+		ActiveFrom    *time.Time `json:"active_from"`
+		ExpiresAt     *time.Time `json:"expires_at"`
+		TTLSeconds    int        `json:"ttl_seconds"`
+		Permanent     bool       `json:"permanent"`
+		StripTracking bool       `json:"strip_tracking"`
+		DryRun        bool       `json:"dry_run"`
+		Wildcard      bool       `json:"wildcard"`
+		Reservation   string     `json:"reservation"`
+		Note          string     `json:"note"`
+	}
+	url := new(urlPost)
+
+	idempotencyKey := scopedIdempotencyKey(c)
+	if idempotencyKey != "" {
+		data, found, pending := idempotencyCache.Begin(idempotencyKey)
+		if pending {
+			msg := "A request with this Idempotency-Key is still running, retry once it is done"
+			return SendResponse(c, MakeErrorResponse(409, "IDEMPOTENCY_IN_PROGRESS", msg))
+		} else if found {
+			// A replay returns the short created by the first request, nothing new was created.
+			if data.Created != nil {
+				created := false
+				data.Created = &created
+			}
+			return SendResponse(c, data)
+		}
+		// Frees the key if the request fails, a stored response stays.
+		defer idempotencyCache.Release(idempotencyKey)
+	}
+
+	if data, ok := parseBody(c, url, "url"); !ok {
+		return SendResponse(c, data)
+	}
+
+	limitReached, err := h.links.LimitReached()
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		data = MakeResponse(500, err.Error(), Url{})
+		return SendResponse(c, data)
+	} else if limitReached {
+		msg := fmt.Sprintf("This instance is limited to %d URLs, delete some before creating new ones", conf.MaxLinks)
+		data = MakeErrorResponse(403, "LINK_LIMIT_REACHED", msg)
+		return SendResponse(c, data)
+	}
+
+	submitted := url.Url
+	target, data, ok := normalizeTarget(url.Url)
+	if !ok {
+		return SendResponse(c, data)
+	}
+	if target, data, ok = checkShortener(c, target); !ok {
+		return SendResponse(c, data)
+	}
+	url.Url = target
+	if url.StripTracking {
+		if parsed, err := uri.Parse(url.Url); err == nil {
+			StripTrackingParams(parsed)
+			url.Url = parsed.String()
+		}
+	}
+
+	if data, ok := h.checkReputation(c, url.Url); !ok {
+		return SendResponse(c, data)
+	}
+	if data, ok := checkReachable(c, url.Url); !ok {
+		return SendResponse(c, data)
+	}
+
+	// Prepare the new url for insertion, either with the requested alias or a newly generated short.
+	// Both end up in the namespace if there is one, e.g. 'team1/<short>'.
+	var prepUrl Url
+	if url.Namespace, data, ok = checkNamespace(url.Namespace); !ok {
+		return SendResponse(c, data)
+	}
+	if url.Alias != "" {
+		short, data, ok := h.checkAlias(c, url.Alias, url.Namespace, url.Reservation)
+		if !ok && data.Code == "ALIAS_TAKEN" && conf.AliasConflict == aliasConflictUpdate {
+			taken := Namespaced(url.Namespace, canonicalShort(url.Alias))
+			dryRun := url.DryRun || c.Query("dry_run") == "true"
+			return h.updateTakenAlias(c, taken, url.Url, originalUrl(submitted, url.Url), dryRun)
+		} else if !ok {
+			return SendResponse(c, data)
+		}
+		prepUrl = MakeUrl(url.Url, short, StatusActive)
+	} else {
+		prepUrl, err = h.dbFor(c).PrepareNewUrl(url.Url, url.Namespace)
+		if errors.Is(err, ErrShortGeneration) {
+			log.Printf("ERROR: %s", err.Error())
+			data = MakeErrorResponse(503, "SHORT_GENERATION_FAILED", "Could not generate a free short, try again later")
+			return SendResponse(c, data)
+		} else if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			data = MakeResponse(500, err.Error(), Url{})
+			return SendResponse(c, data)
+		}
+	}
+	// Only a new short counts towards TLDR_MAX_ALIASES_PER_URL, 'updateTakenAlias' checks its own.
+	if data, ok := h.checkAliasesPerUrl(c, url.Url); !ok {
+		return SendResponse(c, data)
+	}
+	prepUrl.OriginalUrl = originalUrl(submitted, url.Url)
+	if url.MaxClicks < 0 {
+		data = MakeResponse(422, "max_clicks must not be negative", Url{})
+		return SendResponse(c, data)
+	}
+	// Both limits are stored as 'max_uses', one_time is the stricter one.
+	prepUrl.MaxUses = url.MaxClicks
+	if url.OneTime {
+		prepUrl.MaxUses = 1
+	}
+	prepUrl.ActiveFrom = url.ActiveFrom
+
+	// An explicit expiry wins over the default ttl, permanent shorts never expire.
+	if url.TTLSeconds < 0 {
+		data = MakeResponse(422, "ttl_seconds must not be negative", Url{})
+		return SendResponse(c, data)
+	} else if url.ExpiresAt != nil && url.TTLSeconds > 0 {
+		data = MakeResponse(422, "Only one of expires_at and ttl_seconds can be set", Url{})
+		return SendResponse(c, data)
+	} else if url.Permanent && (url.ExpiresAt != nil || url.TTLSeconds > 0) {
+		data = MakeResponse(422, "A permanent URL can't expire", Url{})
+		return SendResponse(c, data)
+	}
+	ttl := conf.DefaultTTL
+	if url.TTLSeconds > 0 {
+		ttl = time.Duration(url.TTLSeconds) * time.Second
+	}
+	if url.ExpiresAt != nil {
+		prepUrl.ExpiresAt = url.ExpiresAt
+	} else if ttl > 0 && !url.Permanent {
+		expires := prepUrl.CreatedAt.Add(ttl)
+		prepUrl.ExpiresAt = &expires
+	}
+	if IsExpired(prepUrl) {
+		data = MakeResponse(422, "expires_at must be in the future", Url{})
+		return SendResponse(c, data)
+	} else if prepUrl.ExpiresAt != nil && prepUrl.ActiveFrom != nil && !prepUrl.ExpiresAt.After(*prepUrl.ActiveFrom) {
+		data = MakeResponse(422, "expires_at must be after active_from", Url{})
+		return SendResponse(c, data)
+	}
+	prepUrl.Tags, err = NormalizeTags(url.Tags)
+	if err != nil {
+		data = MakeResponse(422, err.Error(), Url{})
+		return SendResponse(c, data)
+	}
+	prepUrl.Note, err = NormalizeNote(url.Note)
+	if err != nil {
+		data = MakeResponse(422, err.Error(), Url{})
+		return SendResponse(c, data)
+	}
+	if url.Password != "" {
+		prepUrl.PasswordHash, err = HashPassword(url.Password)
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			data = MakeResponse(500, err.Error(), Url{})
+			return SendResponse(c, data)
+		}
+		prepUrl.Protected = true
+	}
+
+	prepUrl.Owner = requestOwner(c)
+	prepUrl.Wildcard = url.Wildcard
+
+	// A dry run stops right before anything gets stored, the client sees what would be created.
+	if url.DryRun || c.Query("dry_run") == "true" {
+		prepUrl.ShortUrl = ShortUrl(c, prepUrl.Short)
+		data = MakeResponse(200, "Dry run, nothing was created", prepUrl)
+		created := true
+		data.Created = &created
+		return SendResponse(c, data)
+	}
+
+	// Insert the new url, the alias check above can race with a concurrent create of the same alias.
+	err = h.dbFor(c).InsertNewUrl(prepUrl)
+	if errors.Is(err, ErrShortTaken) && url.Alias != "" {
+		msg := fmt.Sprintf("Alias '%s' is already taken.", prepUrl.Short)
+		data = MakeErrorResponse(409, "ALIAS_TAKEN", msg)
+		return SendResponse(c, data)
+	} else if errors.Is(err, ErrShortTaken) {
+		log.Printf("ERROR: Generated short '%s' was taken in the meantime", prepUrl.Short)
+		data = MakeErrorResponse(503, "SHORT_GENERATION_FAILED", "Could not generate a free short, try again later")
+		return SendResponse(c, data)
+	} else if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		data = MakeResponse(500, err.Error(), Url{})
+		return SendResponse(c, data)
+	}
+
+	h.links.Added()
+	if url.Alias != "" {
+		reservations.Release(prepUrl.Short)
+	}
+	if url.Favicon {
+		h.dbFor(c).EnrichFavicon(prepUrl)
+	}
+	h.webhook.Created(prepUrl)
+	h.events.Created(prepUrl)
+
+	// Send the 200 OK with the newly created url.
+	prepUrl.ShortUrl = ShortUrl(c, prepUrl.Short)
+	data = MakeResponse(200, "Ok", prepUrl)
+	created := true
+	data.Created = &created
+	if idempotencyKey != "" {
+		idempotencyCache.Set(idempotencyKey, data)
+	}
+	return SendResponse(c, data)
+}
+
+// UpdateUrl :: change the target url of an existing short, the short itself stays the same.
+// The new url is validated, normalized and checked (reputation, TLDR_MAX_ALIASES_PER_URL) the same way as on creation,
+// an optional 'note' replaces the note.
+// Put body example:
+//
+//	{
+//		"url": "https://example.com/new",
+//		"note": "Q4 campaign landing page"
+//	}
+func (h handler) UpdateUrl(c *fiber.Ctx) error {
+	short := shortParam(c)
+	if !IsValidShort(short) {
+		return SendResponse(c, invalidShortResponse(short))
+	}
+	type urlPut struct {
+		Url  string  `json:"url"`
+		Note *string `json:"note"`
+	}
+	put := new(urlPut)
+
+	if data, ok := parseBody(c, put, "url"); !ok {
+		return SendResponse(c, data)
+	}
+	target, data, ok := normalizeTarget(put.Url)
+	if !ok {
+		return SendResponse(c, data)
+	}
+	if target, data, ok = checkShortener(c, target); !ok {
+		return SendResponse(c, data)
+	}
+	if data, ok := h.checkReputation(c, target); !ok {
+		return SendResponse(c, data)
+	}
+	if data, ok := checkReachable(c, target); !ok {
+		return SendResponse(c, data)
+	}
+	var note string
+	if put.Note != nil {
+		var err error
+		if note, err = NormalizeNote(*put.Note); err != nil {
+			return SendResponse(c, MakeResponse(422, err.Error(), Url{}))
+		}
+	}
+
+	current, data, ok := h.ownedUrl(c, short)
+	if !ok {
+		return SendResponse(c, data)
+	}
+	// The short only counts against the limit of its new url once it points there.
+	if current.Url != target {
+		if data, ok := h.checkAliasesPerUrl(c, target); !ok {
+			return SendResponse(c, data)
+		}
+	}
+	found, err := h.dbFor(c).UpdateUrl(short, target, originalUrl(put.Url, target))
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	} else if !found {
+		msg := fmt.Sprintf("No URL found for short '%s'.", short)
+		return SendResponse(c, MakeResponse(404, msg, Url{}))
+	}
+	if put.Note != nil {
+		if _, err = h.dbFor(c).UpdateNote(short, note); err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+		}
+	}
+
+	found, url, err := h.dbFor(c).GetUrlFromShort(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	} else if !found {
+		msg := fmt.Sprintf("No URL found for short '%s'.", short)
+		return SendResponse(c, MakeResponse(404, msg, Url{}))
+	}
+	url.ShortUrl = ShortUrl(c, url.Short)
+	return SendResponse(c, MakeResponse(200, "Ok", url))
+}
+
+// PutAlias :: make sure the alias points to the url, for tools that manage their links declaratively.
+// Creates the alias (201) if it doesn't exist and changes its url (200) otherwise, sending the same url again changes nothing.
+// Only the owner of the alias (or an admin key) can change it, see 'mayUpdateAlias'.
+// Everything else a short can have (expiry, password, ...) is left alone when it gets updated.
+func (h handler) PutAlias(c *fiber.Ctx) error {
+	alias := c.Params("alias")
+	if !IsValidCode(alias) {
+		msg := fmt.Sprintf("Alias may only contain letters, digits, '-' and '_' and be at most %d characters long.", maxShortLength)
+		return SendResponse(c, MakeErrorResponse(422, "INVALID_ALIAS", msg))
+	} else if IsDeniedShort(alias) {
+		return SendResponse(c, MakeErrorResponse(422, "DENIED_ALIAS", "Alias contains a word that isn't allowed."))
+	} else if IsReservedShort(alias) {
+		msg := fmt.Sprintf("Alias '%s' is reserved.", alias)
+		return SendResponse(c, MakeErrorResponse(409, "RESERVED_ALIAS", msg))
+	}
+	type aliasPut struct {
+		Url string `json:"url"`
+	}
+	put := new(aliasPut)
+
+	if data, ok := parseBody(c, put, "url"); !ok {
+		return SendResponse(c, data)
+	}
+	target, data, ok := normalizeTarget(put.Url)
+	if !ok {
+		return SendResponse(c, data)
+	}
+	if target, data, ok = checkShortener(c, target); !ok {
+		return SendResponse(c, data)
+	}
+	if data, ok := h.checkReputation(c, target); !ok {
+		return SendResponse(c, data)
+	}
+	if data, ok := checkReachable(c, target); !ok {
+		return SendResponse(c, data)
+	}
+
+	short := canonicalShort(alias)
+	db := h.dbFor(c)
+	found, url, err := db.GetUrlFromShort(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	// Somebody else's alias stays theirs, it looks just like a taken one to the caller.
+	if found && !mayUpdateAlias(c, url) {
+		msg := fmt.Sprintf("Alias '%s' is already taken.", short)
+		return SendResponse(c, MakeErrorResponse(409, "ALIAS_TAKEN", msg))
+	}
+	if reservations.Blocks(short, "") {
+		return SendResponse(c, aliasHeldResponse(short))
+	}
+	created := !found
+	if found && url.Url != target {
+		if data, ok := h.checkAliasesPerUrl(c, target); !ok {
+			return SendResponse(c, data)
+		}
+		url.Url, url.OriginalUrl = target, originalUrl(put.Url, target)
+		if _, err = db.UpdateUrl(short, url.Url, url.OriginalUrl); err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+		}
+	} else if !found {
+		limitReached, err := h.links.LimitReached()
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+		} else if limitReached {
+			msg := fmt.Sprintf("This instance is limited to %d URLs, delete some before creating new ones", conf.MaxLinks)
+			return SendResponse(c, MakeErrorResponse(403, "LINK_LIMIT_REACHED", msg))
+		}
+
+		if data, ok := h.checkAliasesPerUrl(c, target); !ok {
+			return SendResponse(c, data)
+		}
+		url = MakeUrl(target, short, StatusActive)
+		url.OriginalUrl = originalUrl(put.Url, target)
+		url.Owner = requestOwner(c)
+		if conf.DefaultTTL > 0 {
+			expires := url.CreatedAt.Add(conf.DefaultTTL)
+			url.ExpiresAt = &expires
+		}
+		// Two concurrent creates of the same alias, the loser can simply retry and ends up updating.
+		err = db.InsertNewUrl(url)
+		if errors.Is(err, ErrShortTaken) {
+			msg := fmt.Sprintf("Alias '%s' was created in the meantime, try again.", short)
+			return SendResponse(c, MakeErrorResponse(409, "ALIAS_TAKEN", msg))
+		} else if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+		}
+		h.links.Added()
+		h.webhook.Created(url)
+		h.events.Created(url)
+	}
+
+	url.ShortUrl = ShortUrl(c, url.Short)
+	if created {
+		data = MakeResponse(201, "Created", url)
+	} else {
+		data = MakeResponse(200, "Ok", url)
+	}
+	data.Created = &created
+	return SendResponse(c, data)
+}
+
+// PatchUrl :: change the metadata of a short: its tags, status and note, fields that aren't sent stay as they are.
+// An empty note removes it.
+// Patch body example:
+//
+//	{
+//		"tags": ["work", "docs"],
+//		"status": "disabled",
+//		"note": "Q3 campaign landing page"
+//	}
+func (h handler) PatchUrl(c *fiber.Ctx) error {
+	short := shortParam(c)
+	if !IsValidShort(short) {
+		return SendResponse(c, invalidShortResponse(short))
+	}
+	type urlPatch struct {
+		Tags   *[]string `json:"tags"`
+		Status *string   `json:"status"`
+		Note   *string   `json:"note"`
+	}
+	patch := new(urlPatch)
+
+	if data, ok := parseBody(c, patch); !ok {
+		return SendResponse(c, data)
+	}
+	if _, data, ok := h.ownedUrl(c, short); !ok {
+		return SendResponse(c, data)
+	}
+	if patch.Tags != nil {
+		tags, err := NormalizeTags(*patch.Tags)
+		if err != nil {
+			return SendResponse(c, MakeResponse(422, err.Error(), Url{}))
+		}
+		found, err := h.dbFor(c).UpdateTags(short, tags)
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+		} else if !found {
+			msg := fmt.Sprintf("No URL found for short '%s'.", short)
+			return SendResponse(c, MakeResponse(404, msg, Url{}))
+		}
+	}
+	if patch.Note != nil {
+		note, err := NormalizeNote(*patch.Note)
+		if err != nil {
+			return SendResponse(c, MakeResponse(422, err.Error(), Url{}))
+		}
+		found, err := h.dbFor(c).UpdateNote(short, note)
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+		} else if !found {
+			msg := fmt.Sprintf("No URL found for short '%s'.", short)
+			return SendResponse(c, MakeResponse(404, msg, Url{}))
+		}
+	}
+	if patch.Status != nil {
+		status, err := ParseStatus(*patch.Status)
+		if err != nil {
+			return SendResponse(c, MakeResponse(422, err.Error(), Url{}))
+		}
+		found, err := h.dbFor(c).UpdateStatus(short, status)
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+		} else if !found {
+			msg := fmt.Sprintf("No URL found for short '%s'.", short)
+			return SendResponse(c, MakeResponse(404, msg, Url{}))
+		}
+	}
+
+	found, url, err := h.dbFor(c).GetUrlFromShort(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	} else if !found {
+		msg := fmt.Sprintf("No URL found for short '%s'.", short)
+		return SendResponse(c, MakeResponse(404, msg, Url{}))
+	}
+	url.ShortUrl = ShortUrl(c, url.Short)
+	return SendResponse(c, MakeResponse(200, "Ok", url))
+}
+
+// DeleteUrls :: delete the urls of the posted shorts ('{"shorts": [...]}'), returns how many of them existed.
+// Keys that aren't admin keys only delete their own urls, the shorts of other owners are skipped like unknown ones.
+func (h handler) DeleteUrls(c *fiber.Ctx) error {
+	type deletePost struct {
+		Shorts []string `json:"shorts"`
+	}
+	post := new(deletePost)
+
+	if data, ok := parseBody(c, post, "shorts"); !ok {
+		return SendResponse(c, data)
+	}
+	if len(post.Shorts) > maxDeleteShorts {
+		msg := fmt.Sprintf("At most %d shorts can be deleted at once.", maxDeleteShorts)
+		return SendResponse(c, MakeResponse(422, msg, Url{}))
+	}
+
+	var shorts []string
+	for _, short := range post.Shorts {
+		if IsValidShort(short) {
+			shorts = append(shorts, short)
+		}
+	}
+	removed, err := h.dbFor(c).DeleteUrls(shorts, listOwner(c))
+	h.links.Invalidate()
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	deleted := removed.Count()
+	log.Printf("INFO: Deleted %d of %d URLs (request %s)", deleted, len(post.Shorts), RequestID(c))
+	h.events.Deleted(removed)
+
+	type deleteResult struct {
+		Deleted int64
+	}
+	return SendPayload(c, 200, "Ok", deleteResult{Deleted: deleted})
+}
+
+// DeleteAll :: delete ALL urls, this is meant for resetting test environments.
+// Requires authentication and '?confirm=true' to make sure nobody wipes the database by accident.
+func (h handler) DeleteAll(c *fiber.Ctx) error {
+	if c.Query("confirm") != "true" {
+		data := MakeResponse(400, "Deleting all URLs requires ?confirm=true", Url{})
+		return SendResponse(c, data)
+	}
+
+	deleted, err := h.dbFor(c).DeleteAll()
+	h.links.Invalidate()
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		data := MakeResponse(500, err.Error(), Url{})
+		return SendResponse(c, data)
+	}
+	log.Printf("WARN: Deleted all %d URLs (request %s)", deleted, RequestID(c))
+	h.events.DeletedAll(deleted)
+
+	type deleteResult struct {
+		Deleted int64
+	}
+	return SendPayload(c, 200, "Ok", deleteResult{Deleted: deleted})
+}
+
+// Maintenance :: checkpoint the wal and VACUUM the database, returns the size before and after.
+func (h handler) Maintenance(c *fiber.Ctx) error {
+	result, err := h.db.Maintain()
+	if errors.Is(err, ErrMaintenanceRunning) {
+		return SendResponse(c, MakeErrorResponse(409, "MAINTENANCE_RUNNING", "The maintenance is running already, wait for it to finish"))
+	} else if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	return SendPayload(c, 200, "Ok", result)
+}
+
+// GetStats :: returns the clicks per day, the top referrers and the clicks per country of a short, '?days=' controls how many days to look back (default 30, max 365).
+func (h handler) GetStats(c *fiber.Ctx) error {
+	var err error
+	short := shortParam(c)
+	if !IsValidShort(short) {
+		return SendResponse(c, invalidShortResponse(short))
+	}
+	days := defaultStatsDays
+	if c.Query("days") != "" {
+		days, err = strconv.Atoi(c.Query("days"))
+		if err != nil || days < 1 || days > maxStatsDays {
+			msg := fmt.Sprintf("days must be a number between 1 and %d", maxStatsDays)
+			return SendResponse(c, MakeResponse(400, msg, Url{}))
+		}
+	}
+
+	if _, data, ok := h.ownedUrl(c, short); !ok {
+		return SendResponse(c, data)
+	}
+
+	clicks, err := h.dbFor(c).GetDailyClicks(short, days)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	referrers, err := h.dbFor(c).GetTopReferrers(short, days)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	countries, err := h.dbFor(c).GetCountryClicks(short, days)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	stats := ClickStats{Short: short, Days: clicks, TopReferrers: referrers, Countries: countries}
+	return SendPayload(c, 200, "Ok", stats)
+}
+
+// BatchStats :: the clicks, latest click and validity of many shorts at once, e.g. for a dashboard table of links.
+// Every requested short shows up in the result, unknown ones (and those of other owners for keys that aren't admin keys)
+// with 'Found' false.
+// Post body example:
+//
+//	{
+//		"shorts": ["rdt", "yt"]
+//	}
+func (h handler) BatchStats(c *fiber.Ctx) error {
+	type statsPost struct {
+		Shorts []string `json:"shorts"`
+	}
+	post := new(statsPost)
+
+	if data, ok := parseBody(c, post, "shorts"); !ok {
+		return SendResponse(c, data)
+	}
+	if len(post.Shorts) > maxResolveShorts {
+		msg := fmt.Sprintf("At most %d shorts can be looked up at once.", maxResolveShorts)
+		return SendResponse(c, MakeResponse(422, msg, Url{}))
+	}
+
+	var lookup []string
+	for _, short := range post.Shorts {
+		if IsValidShort(short) {
+			lookup = append(lookup, short)
+		}
+	}
+	stats, err := h.dbFor(c).GetBatchStats(lookup, listOwner(c))
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+
+	result := make(map[string]LinkStats, len(post.Shorts))
+	for _, short := range post.Shorts {
+		result[short] = stats[canonicalShort(short)]
+	}
+	return SendPayload(c, 200, "Ok", result)
+}
+
+// Resolution :: the result of a single short in a batch lookup, see 'ResolveShorts'.
+type Resolution struct {
+	Url       string
+	Valid     bool
+	Found     bool
+	Protected bool `json:",omitempty"`
+}
+
+// ResolveShorts :: look up many shorts at once, e.g. to render a page full of links with a single request.
+// Unlike 'GetUrl' this doesn't count as a use, and every requested short shows up in the result, unknown ones with 'Found' false.
+// With TLDR_SIGNING_SECRET shorts without a valid signature count as unknown.
+// Post body example:
+//
+//	{
+//		"shorts": ["rdt", "yt"]
+//	}
+func (h handler) ResolveShorts(c *fiber.Ctx) error {
+	type resolvePost struct {
+		Shorts []string `json:"shorts"`
+	}
+	post := new(resolvePost)
+
+	if data, ok := parseBody(c, post, "shorts"); !ok {
+		return SendResponse(c, data)
+	}
+	if len(post.Shorts) > maxResolveShorts {
+		msg := fmt.Sprintf("At most %d shorts can be resolved at once.", maxResolveShorts)
+		return SendResponse(c, MakeResponse(422, msg, Url{}))
+	}
+
+	// Shorts that can't exist don't need to hit the database, with signing on that includes unsigned shorts.
+	var lookup []string
+	verified := make(map[string]string, len(post.Shorts))
+	for _, short := range post.Shorts {
+		if bare, ok := VerifyShort(short); ok && IsValidShort(bare) {
+			verified[short] = bare
+			lookup = append(lookup, bare)
+		}
+	}
+	urls, err := h.dbFor(c).GetUrlsByShorts(lookup)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+
+	result := make(map[string]Resolution, len(post.Shorts))
+	for _, short := range post.Shorts {
+		bare, ok := verified[short]
+		url, found := urls[canonicalShort(bare)]
+		if !ok || !found {
+			result[short] = Resolution{}
+			continue
+		}
+		res := Resolution{
+			Url:       url.Url,
+			Valid:     IsValid(url) && !IsUsedUp(url) && !IsExpired(url) && IsActive(url),
+			Found:     true,
+			Protected: IsProtected(url),
+		}
+		// Don't leak the target of password protected urls.
+		if res.Protected {
+			res.Url = ""
+		}
+		result[short] = res
+	}
+	return SendPayload(c, 200, "Ok", result)
+}
+
+// ShortExists :: cheap check if a short is taken (e.g. while typing an alias), 204 if it is, 404 if not.
+// There is no body and nothing counts as a use. With TLDR_CONSTANT_TIME_LOOKUP the 404 takes as long as a 204,
+// the status of course still tells. With TLDR_SIGNING_SECRET shorts without a valid signature are a 404.
+func (h handler) ShortExists(c *fiber.Ctx) error {
+	status := 0
+	if conf.ConstantTimeLookup {
+		defer existsTimes.Track(time.Now(), &status)
+	}
+	// Without its signature a short doesn't exist, else this would tell which bare shorts are worth signing.
+	short, ok := VerifyShort(shortParam(c))
+	if !ok {
+		status = 404
+		return c.Status(status).Send(nil)
+	}
+	if !IsValidShort(short) {
+		status = 400
+		return c.Status(status).Send(nil)
+	}
+	found, err := h.dbFor(c).ShortExists(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		status = 500
+	} else if !found {
+		status = 404
+	} else {
+		status = 204
+	}
+	return c.Status(status).Send(nil)
+}
+
+// TargetCheck :: the result of requesting the target of a short, see 'CheckTarget'.
+type TargetCheck struct {
+	Short     string
+	Url       string
+	Reachable bool
+	Status    int    `json:",omitempty"`
+	FinalUrl  string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+	// TooManyRedirects is set if the target redirected more often than TLDR_MAX_REDIRECTS allows.
+	TooManyRedirects bool `json:",omitempty"`
+}
+
+// CheckTarget :: request the target of the short and report the status and the url it ends up at after redirects.
+// This doesn't count as a use and doesn't change the short, protected shorts need their password.
+func (h handler) CheckTarget(c *fiber.Ctx) error {
+	short := shortParam(c)
+	if !IsValidShort(short) {
+		return SendResponse(c, invalidShortResponse(short))
+	}
+	found, url, err := h.dbFor(c).GetUrlFromShort(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	} else if !found {
+		msg := fmt.Sprintf("No URL found for short '%s'.", short)
+		return SendResponse(c, MakeResponse(404, msg, Url{}))
+	}
+	if IsProtected(url) && !CheckPassword(url.PasswordHash, linkPassword(c)) {
+		data := MakeErrorResponse(401, "PASSWORD_REQUIRED", "URL is password protected, provide the correct password")
+		return SendResponse(c, data)
+	}
+
+	// Old urls were stored without scheme, check them the way they would be stored today.
+	target, data, ok := normalizeTarget(url.Url)
+	if !ok {
+		return SendResponse(c, data)
+	}
+	check := TargetCheck{Short: url.Short, Url: target}
+	check.Status, check.FinalUrl, err = CheckTarget(c.UserContext(), target)
+	if err != nil {
+		check.Error = err.Error()
+		check.TooManyRedirects = errors.Is(err, ErrTooManyRedirects)
+	} else {
+		check.Reachable = check.Status < 400
+	}
+	return SendPayload(c, 200, "Ok", check)
+}
+
+// GetUrl :: this route get's invoked with a paramaeter (the short to unvail).
+// It requests the given parameter (short url) and returns the redirect url.
+// The url is also sent as 'Location' header, clients that want to follow it don't have to parse the body.
+// A '.json' suffix is the same as none, with '.txt' the response is just the url as plain text.
+func (h handler) GetUrl(c *fiber.Ctx) error {
+	short, format := splitFormat(c.Params("*"))
+	data := h.resolveShort(c, short)
+	if data.Status == 200 {
+		c.Set(fiber.HeaderLocation, data.Data.Url)
+	}
+	if format == "txt" {
+		c.Status(data.Status).Type("txt", "utf-8")
+		if data.Status == 200 {
+			return c.SendString(data.Data.Url + "\n")
+		}
+		return c.SendString(data.Message + "\n")
+	}
+	return SendResponse(c, data)
+}
+
+// formatExtensions :: the extensions 'GetUrl' understands, anything else stays part of the short.
+var formatExtensions = []string{"json", "txt"}
+
+// splitFormat :: split a known extension ('.json', '.txt') off the short, returns the short and the extension.
+// Shorts can't contain a '.', so a known suffix is never part of a real short.
+func splitFormat(param string) (string, string) {
+	for _, ext := range formatExtensions {
+		if strings.HasSuffix(param, "."+ext) {
+			return strings.TrimSuffix(param, "."+ext), ext
+		}
+	}
+	return param, ""
+}
+
+// Redirect :: public redirect, resolves the short like 'GetUrl' does and redirects to its url.
+// Failed lookups get the usual json response.
+func (h handler) Redirect(c *fiber.Ctx) error {
+	short, rest := h.splitWildcard(c, c.Params("*"))
+	data := h.resolveShort(c, short)
+	// People clicking a dead link get a page that explains it instead of a json error, if there is one.
+	if data.Status == 410 && conf.ExpiredRedirect != "" {
+		return c.Redirect(conf.ExpiredRedirect, fiber.StatusFound)
+	} else if data.Status == 422 && conf.InvalidRedirect != "" {
+		return c.Redirect(conf.InvalidRedirect, fiber.StatusFound)
+	} else if data.Status != 200 {
+		return SendResponse(c, data)
+	}
+	if rest != "" {
+		return c.Redirect(expandTarget(data.Data.Url, rest, c.Context().QueryArgs().String()), fiber.StatusFound)
+	}
+	return c.Redirect(data.Data.Url, fiber.StatusFound)
+}
+
+// resolveShort :: look up the short and make sure it may be used, every successful resolve counts as a click.
+// Returns the response to send, with status 200 and the url if the short resolved.
+func (h handler) resolveShort(c *fiber.Ctx, short string) (data Data) {
+	if conf.ConstantTimeLookup {
+		defer lookupTimes.Track(time.Now(), &data.Status)
+	}
+	// The list owns '/api/', but e.g. '/api/.json' still ends up in the catch-all without a short, there is nothing to look up.
+	if isEmptyShort(short) {
+		return MakeErrorResponse(400, "MISSING_SHORT", "A short is required, GET /api/ lists the urls")
+	}
+	short, ok := VerifyShort(short)
+	if !ok {
+		return MakeErrorResponse(403, "INVALID_SIGNATURE", "The short is not signed or the signature doesn't match")
+	}
+	if !IsValidShort(short) {
+		return invalidShortResponse(short)
+	}
+	url, found := cachedUrls.Get(short)
+	if !found {
+		var err error
+		found, url, err = h.dbFor(c).GetUrlFromShort(short)
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			return MakeResponse(500, err.Error(), Url{})
+		} else if !found {
+			msg := fmt.Sprintf("No URL found for short '%s'.", short)
+			return MakeResponse(404, msg, Url{})
+		}
+		cachedUrls.Set(url)
+	}
+	// Make sure the URL is valid..
+	if IsUsedUp(url) {
+		return MakeResponse(410, "URL is used up", Url{})
+	} else if IsExpired(url) || url.Valid == StatusExpired {
+		return MakeErrorResponse(410, "EXPIRED", "URL is expired")
+	} else if url.Valid == StatusFlagged {
+		return MakeErrorResponse(403, "FLAGGED", "URL has been flagged and is blocked")
+	} else if !IsValid(url) {
+		return MakeResponse(422, "URL is not valid", Url{})
+	} else if !IsActive(url) {
+		msg := fmt.Sprintf("URL is not active yet, it will be from %s on", url.ActiveFrom.Format(time.RFC3339))
+		return MakeErrorResponse(403, "NOT_YET_ACTIVE", msg)
+	}
+	// Protected urls only resolve with the right password ('X-Link-Password' header or '?password=').
+	if IsProtected(url) && !CheckPassword(url.PasswordHash, linkPassword(c)) {
+		return MakeErrorResponse(401, "PASSWORD_REQUIRED", "URL is password protected, provide the correct password")
+	}
+	// Count the use, this fails if a concurrent request used the last available use in the meantime.
+	used, err := h.dbFor(c).UseUrl(url.Short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return MakeResponse(500, err.Error(), Url{})
+	} else if !used {
+		// The cached url was behind, the database knows better.
+		cachedUrls.Remove(url.Short)
+		return MakeResponse(410, "URL is used up", Url{})
+	}
+	cachedUrls.AddUse(url.Short)
+	url.Uses++
+	url.ShortUrl = ShortUrl(c, url.Short)
+	h.clicks.Log(c, url.Short)
+	return MakeResponse(200, "Ok", url)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCreateUrlAlias(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"TLDR_RESERVED": "blocked"})
+	tests := []struct {
+		alias  string
+		status int
+		code   string
+	}{
+		{"example", 200, ""},
+		{"example", 409, "ALIAS_TAKEN"},
+		{"api", 409, "RESERVED_ALIAS"},
+		{"readyz", 409, "RESERVED_ALIAS"},
+		{"blocked", 409, "RESERVED_ALIAS"},
+		{"with space", 422, "INVALID_ALIAS"},
+		{"slash/inside", 422, "INVALID_ALIAS"},
+		{"ümlaut", 422, "INVALID_ALIAS"},
+	}
+	for _, test := range tests {
+		status, resp := send(t, app, "POST", "/api/", `{"url": "https://example.com", "alias": "`+test.alias+`"}`)
+		if status != test.status || resp.Code != test.code {
+			t.Errorf("alias %q: %d %s, want %d %s", test.alias, status, resp.Code, test.status, test.code)
+		}
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"TLDR_API_KEYS": "alice,bob,admin", "TLDR_ADMIN_KEYS": "admin"})
+	alice, bob, admin := []string{"X-API-Key", "alice"}, []string{"X-API-Key", "bob"}, []string{"X-API-Key", "admin"}
+	short := create(t, app, `{"url": "https://example.com/alice", "alias": "alices"}`, alice...)
+	bobs := create(t, app, `{"url": "https://example.com/bob"}`, bob...)
+
+	// Every key lists only its own urls.
+	if _, shorts := listShorts(t, app, bob...); len(shorts) != 1 || shorts[0] != bobs {
+		t.Errorf("the other key lists %v, want only %s", shorts, bobs)
+	}
+	if _, shorts := listShorts(t, app, alice...); len(shorts) != 1 || shorts[0] != short {
+		t.Errorf("the owner lists %v, want only %s", shorts, short)
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		status       int
+	}{
+		{"update", "PUT", "/api/alices", `{"url": "https://example.com/bob"}`, 404},
+		{"patch", "PATCH", "/api/alices", `{"status": "disabled"}`, 404},
+		{"alias", "PUT", "/api/alias/alices", `{"url": "https://example.com/bob"}`, 409},
+		{"stats", "GET", "/api/alices/stats", "", 404},
+	}
+	for _, test := range tests {
+		if status, _ := send(t, app, test.method, test.path, test.body, bob...); status != test.status {
+			t.Errorf("%s by another key: %d, want %d", test.name, status, test.status)
+		}
+	}
+	if _, resp := send(t, app, "POST", "/api/delete", `{"shorts": ["`+short+`"]}`, bob...); resp.Data["Deleted"] != 0.0 {
+		t.Errorf("another key deleted %v urls, want 0", resp.Data["Deleted"])
+	}
+	if status, _ := send(t, app, "DELETE", "/api/?confirm=true", "", bob...); status != 403 {
+		t.Errorf("deleting everything without an admin key: %d, want 403", status)
+	}
+
+	// The url is untouched, its owner (and the admin) may still change it.
+	if _, resp := send(t, app, "GET", "/api/alices", "", alice...); resp.Data["Url"] != "https://example.com/alice" {
+		t.Fatalf("the url changed to %v", resp.Data["Url"])
+	}
+	if status, _ := send(t, app, "PUT", "/api/alices", `{"url": "https://example.com/new"}`, alice...); status != 200 {
+		t.Errorf("update by the owner: %d, want 200", status)
+	}
+	if status, _ := send(t, app, "PUT", "/api/alias/alices", `{"url": "https://example.com/admin"}`, admin...); status != 200 {
+		t.Errorf("alias update by an admin: %d, want 200", status)
+	}
+	if _, resp := send(t, app, "POST", "/api/delete", `{"shorts": ["`+short+`"]}`, alice...); resp.Data["Deleted"] != 1.0 {
+		t.Errorf("the owner deleted %v urls, want 1", resp.Data["Deleted"])
+	}
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"TLDR_API_KEYS": "alice,bob"})
+	body := `{"url": "https://example.com"}`
+
+	_, first := send(t, app, "POST", "/api/", body, "X-API-Key", "alice", idempotencyHeader, "retry-1")
+	_, replay := send(t, app, "POST", "/api/", body, "X-API-Key", "alice", idempotencyHeader, "retry-1")
+	if first.Data["Short"] != replay.Data["Short"] {
+		t.Errorf("the replay created %v, want the first short %v", replay.Data["Short"], first.Data["Short"])
+	}
+	if first.Created == nil || !*first.Created || replay.Created == nil || *replay.Created {
+		t.Errorf("Created is %v and %v, want true and false", first.Created, replay.Created)
+	}
+
+	// The same key of another client is another request.
+	_, other := send(t, app, "POST", "/api/", body, "X-API-Key", "bob", idempotencyHeader, "retry-1")
+	if other.Data["Short"] == first.Data["Short"] {
+		t.Errorf("another client got the response of the first one")
+	}
+}
+
+func TestDeleteAnonymousUrls(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"TLDR_API_KEYS": "admin"})
+	admin := []string{"X-API-Key", "admin"}
+	// Created without a key, the owner column is NULL.
+	deleted := create(t, app, `{"url": "https://example.com/deleted"}`)
+	purged := create(t, app, `{"url": "https://example.com/purged"}`)
+
+	status, resp := send(t, app, "POST", "/api/delete", `{"shorts": ["`+deleted+`"]}`, admin...)
+	if status != 200 || resp.Data["Deleted"] != 1.0 {
+		t.Errorf("deleting an anonymous url: %d %s %v, want 1 deleted", status, resp.Message, resp.Data["Deleted"])
+	}
+
+	if status, _ := send(t, app, "PATCH", "/api/"+purged, `{"status": "disabled"}`, admin...); status != 200 {
+		t.Fatalf("disabling the url: %d", status)
+	}
+	status, resp = send(t, app, "POST", "/api/purge?scope=invalid", "", admin...)
+	if status != 200 || resp.Data["Deleted"] != 1.0 {
+		t.Errorf("purging an anonymous url: %d %s %v, want 1 deleted", status, resp.Message, resp.Data["Deleted"])
+	}
+}
+
+func TestDeleteUrls(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"TLDR_API_KEYS": "alice,bob,admin", "TLDR_ADMIN_KEYS": "admin"})
+	alice, bob, admin := []string{"X-API-Key", "alice"}, []string{"X-API-Key", "bob"}, []string{"X-API-Key", "admin"}
+	first := create(t, app, `{"url": "https://example.com/1", "alias": "first"}`, alice...)
+	second := create(t, app, `{"url": "https://example.com/2", "alias": "second"}`, alice...)
+	anonymous := create(t, app, `{"url": "https://example.com/anonymous", "alias": "anonymous"}`)
+
+	tests := []struct {
+		name    string
+		key     []string
+		shorts  string
+		deleted float64
+	}{
+		// Urls of others (including anonymous ones) are skipped like unknown shorts.
+		{"another key", bob, `["first", "second", "anonymous", "missing"]`, 0},
+		{"the owner", alice, `["first", "missing", "not a short!"]`, 1},
+		{"the owner again", alice, `["first"]`, 0},
+		{"an admin", admin, `["second", "anonymous", "missing"]`, 2},
+	}
+	for _, test := range tests {
+		status, resp := send(t, app, "POST", "/api/delete", `{"shorts": `+test.shorts+`}`, test.key...)
+		if status != 200 || resp.Data["Deleted"] != test.deleted {
+			t.Errorf("%s deleting %s: %d %v, want %v", test.name, test.shorts, status, resp.Data["Deleted"], test.deleted)
+		}
+	}
+	for _, short := range []string{first, second, anonymous} {
+		if status, _ := send(t, app, "GET", "/api/"+short, "", admin...); status != 404 {
+			t.Errorf("%s answers %d after it was deleted, want 404", short, status)
+		}
+	}
+
+	if status, _ := send(t, app, "POST", "/api/delete", `{"shorts": ["first"]}`); status != 401 {
+		t.Errorf("deleting without a key: %d, want 401", status)
+	}
+	many := `"x"` + strings.Repeat(`, "x"`, maxDeleteShorts)
+	if status, _ := send(t, app, "POST", "/api/delete", `{"shorts": [`+many+`]}`, admin...); status != 422 {
+		t.Errorf("deleting %d shorts: %d, want 422", maxDeleteShorts+1, status)
+	}
+}
+
+func TestPutAliasAliasesPerUrl(t *testing.T) {
+	app, db := newTestApp(t, map[string]string{"TLDR_API_KEYS": "alice", "TLDR_MAX_ALIASES_PER_URL": "1"})
+	key := []string{"X-API-Key", "alice"}
+	for _, alias := range []string{"first", "second"} {
+		if status, resp := send(t, app, "PUT", "/api/alias/"+alias, `{"url": "https://example.com/`+alias+`"}`, key...); status != 201 {
+			t.Fatalf("put %s: %d %s", alias, status, resp.Message)
+		}
+	}
+
+	// Pointing an existing alias to a url that has its shorts already counts like a new alias.
+	status, resp := send(t, app, "PUT", "/api/alias/second", `{"url": "https://example.com/first"}`, key...)
+	if status != 409 || resp.Code != "TOO_MANY_ALIASES" {
+		t.Errorf("retarget to a full url: %d %s, want 409 TOO_MANY_ALIASES", status, resp.Code)
+	}
+	if _, url, _ := db.GetUrlFromShort("second"); url.Url != "https://example.com/second" {
+		t.Errorf("the rejected alias points to %s", url.Url)
+	}
+	// Putting the same target again doesn't add a short.
+	if status, resp := send(t, app, "PUT", "/api/alias/first", `{"url": "https://example.com/first"}`, key...); status != 200 {
+		t.Errorf("unchanged put: %d %s, want 200", status, resp.Code)
+	}
+}
+
+func TestCreateUrlTakenAlias(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		key    string
+		target string
+		status int
+		code   string
+		// url is what the alias points to afterwards.
+		url string
+	}{
+		{"reject owner", "reject", "alice", "https://example.com/new", 409, "ALIAS_TAKEN", "https://example.com/taken"},
+		{"reject other", "reject", "bob", "https://example.com/new", 409, "ALIAS_TAKEN", "https://example.com/taken"},
+		{"update owner", "update", "alice", "https://example.com/new", 200, "", "https://example.com/new"},
+		{"update other", "update", "bob", "https://example.com/new", 409, "ALIAS_TAKEN", "https://example.com/taken"},
+		{"update owner to a full url", "update", "alice", "https://example.com/full", 409, "TOO_MANY_ALIASES", "https://example.com/taken"},
+		{"update other to a full url", "update", "bob", "https://example.com/full", 409, "ALIAS_TAKEN", "https://example.com/taken"},
+		// The alias is the one short of its url already, keeping it doesn't add another.
+		{"update owner unchanged", "update", "alice", "https://example.com/taken", 200, "", "https://example.com/taken"},
+	}
+	for _, test := range tests {
+		app, db := newTestApp(t, map[string]string{
+			"TLDR_API_KEYS":            "alice,bob",
+			"TLDR_ADMIN_KEYS":          "admin",
+			"TLDR_ALIAS_CONFLICT":      test.mode,
+			"TLDR_MAX_ALIASES_PER_URL": "1",
+		})
+		alice := []string{"X-API-Key", "alice"}
+		create(t, app, `{"url": "https://example.com/taken", "alias": "taken"}`, alice...)
+		create(t, app, `{"url": "https://example.com/full", "alias": "full"}`, alice...)
+
+		status, resp := send(t, app, "POST", "/api/", `{"url": "`+test.target+`", "alias": "taken"}`, "X-API-Key", test.key)
+		if status != test.status || resp.Code != test.code {
+			t.Errorf("%s: %d %s, want %d %s", test.name, status, resp.Code, test.status, test.code)
+		}
+		if status == 200 && (resp.Created == nil || *resp.Created) {
+			t.Errorf("%s: the update claims to have created the alias", test.name)
+		}
+		if _, url, _ := db.GetUrlFromShort("taken"); url.Url != test.url {
+			t.Errorf("%s: the alias points to %s, want %s", test.name, url.Url, test.url)
+		}
+	}
+}
+
+func TestMissingShort(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"TLDR_LIST_PUBLIC": "true"})
+	short := create(t, app, `{"url": "https://example.com"}`)
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		// body is the start of the response.
+		body string
+	}{
+		// The list owns the bare prefix, the extra slash gets collapsed.
+		{"/api/", 200, "application/json", `[{`},
+		{"/api//", 200, "application/json", `[{`},
+		{"/api/v1/", 200, "application/json", `[{`},
+		{"/api/.json", 400, "application/json", `{"Status":400,"Code":"MISSING_SHORT"`},
+		{"/api/.txt", 400, "text/plain; charset=utf-8", "A short is required"},
+		{"/s/", 400, "application/json", `{"Status":400,"Code":"MISSING_SHORT"`},
+		{"/api/" + short, 200, "application/json", `{"Status":200`},
+		{"/api/" + short + ".txt", 200, "text/plain; charset=utf-8", "https://example.com\n"},
+	}
+	for _, test := range tests {
+		resp, body := sendRaw(t, app, "GET", test.path, "")
+		if resp.StatusCode != test.status || !strings.HasPrefix(resp.Header.Get("Content-Type"), test.contentType) {
+			t.Errorf("%s: %d %s, want %d %s", test.path, resp.StatusCode, resp.Header.Get("Content-Type"), test.status, test.contentType)
+		}
+		if !strings.HasPrefix(string(body), test.body) {
+			t.Errorf("%s answered %s, want it to start with %s", test.path, body, test.body)
+		}
+	}
+}
+
+func TestListAuth(t *testing.T) {
+	tests := []struct {
+		public string
+		key    string
+		status int
+		shorts string
+	}{
+		{"false", "", 401, ""},
+		{"false", "wrong", 401, ""},
+		{"false", "user", 200, "users"},
+		{"false", "admin", 200, "admins anonymous users"},
+		{"true", "", 200, "admins anonymous users"},
+		{"true", "wrong", 200, "admins anonymous users"},
+		{"true", "user", 200, "users"},
+		{"true", "admin", 200, "admins anonymous users"},
+	}
+	for _, test := range tests {
+		app, _ := newTestApp(t, map[string]string{"TLDR_API_KEYS": "user,admin", "TLDR_ADMIN_KEYS": "admin", "TLDR_LIST_PUBLIC": test.public})
+		create(t, app, `{"url": "https://example.com/user", "alias": "users"}`, "X-API-Key", "user")
+		create(t, app, `{"url": "https://example.com/admin", "alias": "admins"}`, "X-API-Key", "admin")
+		create(t, app, `{"url": "https://example.com", "alias": "anonymous"}`)
+
+		var headers []string
+		if test.key != "" {
+			headers = []string{"X-API-Key", test.key}
+		}
+		status, shorts := listShorts(t, app, headers...)
+		sort.Strings(shorts)
+		if got := strings.Join(shorts, " "); status != test.status || got != test.shorts {
+			t.Errorf("public %s, key %q: %d %q, want %d %q", test.public, test.key, status, got, test.status, test.shorts)
+		}
+	}
+}
+
+func TestCreateUrlScheme(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	tests := []struct {
+		url    string
+		status int
+		code   string
+	}{
+		{"javascript:alert(1)", 422, "UNSUPPORTED_SCHEME"},
+		{"JavaScript:alert(1)", 422, "UNSUPPORTED_SCHEME"},
+		{"data:text/html;base64,PHNjcmlwdD4=", 422, "UNSUPPORTED_SCHEME"},
+		{"DATA:text/html,x", 422, "UNSUPPORTED_SCHEME"},
+		{"file:///etc/passwd", 422, "UNSUPPORTED_SCHEME"},
+		{"mailto:someone@example.com", 422, "UNSUPPORTED_SCHEME"},
+		{"vbscript:msgbox", 422, "UNSUPPORTED_SCHEME"},
+		{"https://example.com", 200, ""},
+		{"HTTP://example.com", 200, ""},
+		// A port isn't a scheme, these get https:// prefixed.
+		{"example.com:8080/x", 200, ""},
+		{"localhost:3000", 200, ""},
+	}
+	for _, test := range tests {
+		status, resp := send(t, app, "POST", "/api/", `{"url": "`+test.url+`"}`)
+		if status != test.status || resp.Code != test.code {
+			t.Errorf("url %q: %d %s, want %d %s", test.url, status, resp.Code, test.status, test.code)
+		}
+	}
+}
+
+func TestCountUrls(t *testing.T) {
+	app, db := newTestApp(t, map[string]string{"TLDR_API_KEYS": "admin"})
+	admin := []string{"X-API-Key", "admin"}
+	count := func(query string) interface{} {
+		t.Helper()
+		status, resp := send(t, app, "GET", "/api/count"+query, "", admin...)
+		if status != 200 {
+			t.Fatalf("count%s: %d %s", query, status, resp.Message)
+		}
+		return resp.Data["Count"]
+	}
+	if got := count(""); got != float64(0) {
+		t.Errorf("empty database counts %v", got)
+	}
+
+	var shorts []string
+	for i := 0; i < 3; i++ {
+		shorts = append(shorts, create(t, app, fmt.Sprintf(`{"url": "https://example.com/%d"}`, i), admin...))
+	}
+	if _, err := db.UpdateStatus(shorts[0], StatusDisabled); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  float64
+	}{
+		{"", 3},
+		{"?valid=true", 2},
+		{"?valid=false", 1},
+	}
+	for _, test := range tests {
+		if got := count(test.query); got != test.want {
+			t.Errorf("count%s is %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	if status, resp := send(t, app, "POST", "/api/delete", `{"shorts": ["`+shorts[1]+`"]}`, admin...); status != 200 {
+		t.Fatalf("delete: %d %s", status, resp.Message)
+	}
+	if got := count(""); got != float64(2) {
+		t.Errorf("count after a delete is %v, want 2", got)
+	}
+	if got := count("?valid=true"); got != float64(1) {
+		t.Errorf("valid count after a delete is %v, want 1", got)
+	}
+	if status, _ := send(t, app, "GET", "/api/count?valid=maybe", "", admin...); status != 400 {
+		t.Errorf("count with an invalid filter: %d, want 400", status)
+	}
+}
+
+func TestCreateUrlSnippet(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"TLDR_BASE_URL": "https://tl.dr"})
+	tests := []struct {
+		format      string
+		contentType string
+		body        string
+	}{
+		{"text", "text/plain; charset=utf-8", "https://tl.dr/s/snip0\n"},
+		{"markdown", "text/markdown; charset=utf-8", "[https://tl.dr/s/snip1](https://tl.dr/s/snip1)\n"},
+		{"html", "text/html; charset=utf-8", `<a href="https://tl.dr/s/snip2">https://tl.dr/s/snip2</a>` + "\n"},
+	}
+	for i, test := range tests {
+		body := fmt.Sprintf(`{"url": "https://example.com", "alias": "snip%d"}`, i)
+		resp, raw := sendRaw(t, app, "POST", "/api/?format="+test.format, body)
+		if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != test.contentType {
+			t.Errorf("%s: %d %s, want 200 %s", test.format, resp.StatusCode, resp.Header.Get("Content-Type"), test.contentType)
+		}
+		if string(raw) != test.body {
+			t.Errorf("%s: %q, want %q", test.format, raw, test.body)
+		}
+	}
+
+	// Errors stay readable text, there is no link to show.
+	resp, raw := sendRaw(t, app, "POST", "/api/?format=markdown", `{"url": "javascript:alert(1)"}`)
+	if resp.StatusCode != 422 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") || !strings.HasPrefix(string(raw), "URL scheme") {
+		t.Errorf("markdown error: %d %s %q, want the message as text", resp.StatusCode, resp.Header.Get("Content-Type"), raw)
+	}
+	// An unknown format is json as usual.
+	if _, resp := send(t, app, "POST", "/api/?format=pdf", `{"url": "https://example.com"}`); !strings.HasPrefix(fmt.Sprint(resp.Data["ShortUrl"]), "https://tl.dr/s/") {
+		t.Errorf("json short url is %v, want it on TLDR_BASE_URL", resp.Data["ShortUrl"])
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// skipLog :: returns true if the request shouldn't show up in the request log, i.e. its path is in TLDR_LOG_SKIP.
+// Probes and metrics scrapes hit the api every few seconds and drown everything else.
+func skipLog(c *fiber.Ctx) bool {
+	for _, path := range conf.LogSkip {
+		if c.Path() == conf.BasePath+path {
+			return true
+		}
+	}
+	return false
+}
+
+// Ready :: returns an error unless the database answers and the schema is up to date.
+func (d database) Ready() error {
+	err := d.checkDb()
+	if err != nil {
+		return err
+	}
+	if err = d.db.PingContext(d.context()); err != nil {
+		return err
+	}
+
+	var version, tables int
+	err = d.db.QueryRowContext(d.context(), `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'url'`).Scan(&tables)
+	if err != nil {
+		return err
+	} else if tables == 0 {
+		return fmt.Errorf("table url doesn't exist")
+	}
+	if err = d.db.QueryRowContext(d.context(), `PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	} else if version < len(migrations) {
+		return fmt.Errorf("%d of %d migrations applied", version, len(migrations))
+	}
+	return nil
+}
+
+// readiness :: the payload of '/readyz', besides the database a crowded short space is worth watching.
+type readiness struct {
+	// ShortGeneration tells how crowded the short space is, see 'generationCounter'.
+	ShortGeneration GenerationStats
+}
+
+// registerProbes :: serve '/livez' (the process is up) and '/readyz' (the database is usable) for orchestrators.
+// A failing readiness probe only takes the instance out of rotation, it doesn't get restarted for a database blip.
+// '/readyz' also answers with the short generation numbers, so the collision rate shows up without TLDR_DEBUG.
+func registerProbes(router fiber.Router, db database) {
+	router.Get("/livez", func(c *fiber.Ctx) error {
+		return SendPayload(c, 200, "Ok", nil)
+	})
+	router.Get("/readyz", func(c *fiber.Ctx) error {
+		ready := readiness{ShortGeneration: shortGenerations.Stats()}
+		if err := db.WithContext(c.UserContext()).Ready(); err != nil {
+			log.Printf("WARN: Not ready: %s", err.Error())
+			return SendPayload(c, 503, err.Error(), ready)
+		}
+		return SendPayload(c, 200, "Ok", ready)
+	})
+}
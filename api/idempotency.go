@@ -0,0 +1,111 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyRetention = 24 * time.Hour
+	idempotencyMaxKeys   = 10000
+)
+
+type idempotencyEntry struct {
+	key  string
+	data Data
+	// pending is set while the first request with the key is still running, it has no response yet.
+	pending bool
+	expires time.Time
+}
+
+// scopedIdempotencyKey :: the key of the 'Idempotency-Key' header in the store, scoped to the owner of the api key
+// (or the client ip without one) so a client never gets the response of another client that picked the same key.
+// Empty if the request has no such header.
+func scopedIdempotencyKey(c *fiber.Ctx) string {
+	key := c.Get(idempotencyHeader)
+	if key == "" {
+		return ""
+	}
+	return requestClient(c) + "\x00" + key
+}
+
+// idempotencyStore :: in-memory LRU that maps an idempotency key to the response that was sent for it.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxKeys int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// newIdempotencyStore :: create a new store, keys expire after the given ttl.
+func newIdempotencyStore(ttl time.Duration, maxKeys int) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Begin :: claim the key for a request. Returns the stored response if the key was used before and pending if
+// another request with the key is still running, otherwise the key is pending until 'Set' or 'Release'.
+// Expired keys are treated as unknown.
+func (s *idempotencyStore) Begin(key string) (data Data, found, pending bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		entry := elem.Value.(*idempotencyEntry)
+		if time.Now().Before(entry.expires) {
+			s.order.MoveToFront(elem)
+			return entry.data, !entry.pending, entry.pending
+		}
+		s.order.Remove(elem)
+		delete(s.entries, key)
+	}
+	s.add(&idempotencyEntry{key: key, pending: true, expires: time.Now().Add(s.ttl)})
+	return Data{}, false, false
+}
+
+// Release :: give up the claim of 'Begin' without a response (the request failed), the key can be used again.
+// Keys that have a response are left alone.
+func (s *idempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok && elem.Value.(*idempotencyEntry).pending {
+		s.order.Remove(elem)
+		delete(s.entries, key)
+	}
+}
+
+// Set :: remember the response for the key, the least recently used key gets evicted when the store is full.
+func (s *idempotencyStore) Set(key string, data Data) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		entry := elem.Value.(*idempotencyEntry)
+		entry.data = data
+		entry.pending = false
+		entry.expires = time.Now().Add(s.ttl)
+		s.order.MoveToFront(elem)
+		return
+	}
+	s.add(&idempotencyEntry{key: key, data: data, expires: time.Now().Add(s.ttl)})
+}
+
+// add :: store a new entry, the least recently used one gets evicted when the store is full. Needs the lock.
+func (s *idempotencyStore) add(entry *idempotencyEntry) {
+	s.entries[entry.key] = s.order.PushFront(entry)
+	for s.order.Len() > s.maxKeys {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*idempotencyEntry).key)
+	}
+}
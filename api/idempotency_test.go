@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	store := newIdempotencyStore(time.Hour, 10)
+
+	if _, found, pending := store.Begin("key"); found || pending {
+		t.Fatalf("a new key is found %v and pending %v", found, pending)
+	}
+	// A concurrent retry while the first request runs.
+	if _, found, pending := store.Begin("key"); found || !pending {
+		t.Fatalf("a running key is found %v and pending %v, want pending", found, pending)
+	}
+
+	store.Set("key", MakeResponse(200, "Ok", Url{Short: "abc"}))
+	data, found, pending := store.Begin("key")
+	if !found || pending || data.Data.Short != "abc" {
+		t.Fatalf("a done key is found %v and pending %v with %q, want the stored response", found, pending, data.Data.Short)
+	}
+	// Releasing a key with a response keeps the response.
+	store.Release("key")
+	if _, found, _ := store.Begin("key"); !found {
+		t.Error("Release dropped a stored response")
+	}
+
+	// A failed request frees its key for the next try.
+	store.Begin("failed")
+	store.Release("failed")
+	if _, found, pending := store.Begin("failed"); found || pending {
+		t.Errorf("a released key is found %v and pending %v", found, pending)
+	}
+}
+
+func TestIdempotencyStoreExpiry(t *testing.T) {
+	store := newIdempotencyStore(time.Millisecond, 10)
+	store.Set("key", MakeResponse(200, "Ok", Url{}))
+	time.Sleep(5 * time.Millisecond)
+	if _, found, pending := store.Begin("key"); found || pending {
+		t.Errorf("an expired key is found %v and pending %v", found, pending)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	jsonStylePascal = "pascal"
+	jsonStyleCamel  = "camel"
+)
+
+// sendJSON :: send v as json in the configured TLDR_JSON_STYLE.
+func sendJSON(c *fiber.Ctx, status int, v interface{}) error {
+	body, err := styledJSON(v)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Status(status).Send(body)
+}
+
+// styledJSON :: marshal v, with camelCase field names if TLDR_JSON_STYLE is camel.
+// The field names of the structs are PascalCase, that's what every response had before the style became configurable.
+func styledJSON(v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil || conf.JSONStyle != jsonStyleCamel {
+		return body, err
+	}
+	// The keys of a map payload are data (e.g. the shorts of 'ResolveShorts'), not field names.
+	keepDataKeys := false
+	if payload, ok := v.(Payload); ok && payload.Data != nil {
+		keepDataKeys = reflect.ValueOf(payload.Data).Kind() == reflect.Map
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var out bytes.Buffer
+	if err = camelValue(decoder, &out, keepDataKeys, false); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// camelValue :: copy the next json value from the decoder to out, renaming the object keys with 'camelKey'.
+// keepDataKeys leaves the keys of the object at 'Data' alone, keepKeys the keys of this value itself.
+func camelValue(decoder *json.Decoder, out *bytes.Buffer, keepDataKeys, keepKeys bool) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		value, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		out.Write(value)
+		return nil
+	}
+
+	out.WriteRune(rune(delim))
+	for i := 0; decoder.More(); i++ {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		keepChildKeys := false
+		if delim == '{' {
+			token, err = decoder.Token()
+			if err != nil {
+				return err
+			}
+			key := token.(string)
+			keepChildKeys = keepDataKeys && key == "Data"
+			if !keepKeys {
+				key = camelKey(key)
+			}
+			name, _ := json.Marshal(key)
+			out.Write(name)
+			out.WriteByte(':')
+		}
+		// Only the direct 'Data' of the envelope can be a map payload.
+		if err = camelValue(decoder, out, false, keepChildKeys); err != nil {
+			return err
+		}
+	}
+	token, err = decoder.Token()
+	if err != nil {
+		return err
+	}
+	out.WriteRune(rune(token.(json.Delim)))
+	return nil
+}
+
+// camelKey :: the camelCase version of a PascalCase field name, e.g. 'ShortUrl' -> 'shortUrl', 'RequestID' -> 'requestId'.
+// A leading acronym is lowercased as a whole ('WAL' -> 'wal'), keys that are camelCase or snake_case already stay.
+func camelKey(key string) string {
+	runes := []rune(key)
+	upper := 0
+	for upper < len(runes) && unicode.IsUpper(runes[upper]) {
+		upper++
+	}
+	// In 'IPAddress' the 'A' starts the next word.
+	if upper > 1 && upper < len(runes) {
+		upper--
+	}
+	for i := 0; i < upper; i++ {
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	key = string(runes)
+	if strings.HasSuffix(key, "ID") {
+		key = strings.TrimSuffix(key, "ID") + "Id"
+	}
+	return key
+}
+
+// camelSchema :: a copy of the openapi document with the properties of every schema renamed with 'camelKey'.
+func camelSchema(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if properties, ok := value.(map[string]interface{}); ok && key == "properties" {
+				camel := make(map[string]interface{}, len(properties))
+				for name, property := range properties {
+					camel[camelKey(name)] = camelSchema(property)
+				}
+				renamed[key] = camel
+				continue
+			}
+			renamed[key] = camelSchema(value)
+		}
+		return renamed
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = camelSchema(item)
+		}
+		return items
+	}
+	return v
+}
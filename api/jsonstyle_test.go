@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCamelKey(t *testing.T) {
+	tests := map[string]string{
+		"Url":        "url",
+		"ShortUrl":   "shortUrl",
+		"RequestID":  "requestId",
+		"ID":         "id",
+		"WAL":        "wal",
+		"IPAddress":  "ipAddress",
+		"shortUrl":   "shortUrl",
+		"created_at": "created_at",
+	}
+	for key, want := range tests {
+		if got := camelKey(key); got != want {
+			t.Errorf("camelKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestJSONStyle(t *testing.T) {
+	tests := []struct {
+		style string
+		// present and absent are keys of the envelope and of its 'Data'.
+		present, absent []string
+		data, shortUrl  string
+	}{
+		{"pascal", []string{"Status", "Message", "RequestID"}, []string{"status", "requestId"}, "Data", "ShortUrl"},
+		{"camel", []string{"status", "message", "requestId"}, []string{"Status", "RequestID"}, "data", "shortUrl"},
+	}
+	for _, test := range tests {
+		app, _ := newTestApp(t, map[string]string{"TLDR_JSON_STYLE": test.style})
+		_, raw := sendRaw(t, app, "POST", "/api/", `{"url": "https://example.com", "alias": "MixedCase"}`)
+		var created map[string]interface{}
+		if err := json.Unmarshal(raw, &created); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range test.present {
+			if _, ok := created[key]; !ok {
+				t.Errorf("%s: %s is missing in %s", test.style, key, raw)
+			}
+		}
+		for _, key := range test.absent {
+			if _, ok := created[key]; ok {
+				t.Errorf("%s: %s shouldn't be in %s", test.style, key, raw)
+			}
+		}
+		url, _ := created[test.data].(map[string]interface{})
+		if _, ok := url[test.shortUrl]; !ok {
+			t.Errorf("%s: the url has no %s in %s", test.style, test.shortUrl, raw)
+		}
+
+		// The shorts are the keys of the resolve payload, they are data and stay as they are.
+		_, raw = sendRaw(t, app, "POST", "/api/resolve", `{"shorts": ["MixedCase"]}`)
+		var resolved map[string]interface{}
+		if err := json.Unmarshal(raw, &resolved); err != nil {
+			t.Fatal(err)
+		}
+		shorts, _ := resolved[test.data].(map[string]interface{})
+		if _, ok := shorts["MixedCase"]; !ok {
+			t.Errorf("%s: the short got renamed in %s", test.style, raw)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// linkCountTTL :: how long a counted number of links is trusted before counting again.
+const linkCountTTL = 5 * time.Second
+
+// linkCounter :: caches the number of urls for the TLDR_MAX_LINKS check, so not every create has to count the table.
+// Creates and deletes through the api keep the cached number up to date, the ttl catches everything else.
+type linkCounter struct {
+	mu      sync.Mutex
+	db      database
+	count   int64
+	expires time.Time
+}
+
+func newLinkCounter(db database) *linkCounter {
+	return &linkCounter{db: db}
+}
+
+// Count :: returns the (cached) number of urls.
+func (l *linkCounter) Count() (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Now().Before(l.expires) {
+		return l.count, nil
+	}
+	count, err := l.db.CountUrls(UrlFilter{})
+	if err != nil {
+		return 0, err
+	}
+	l.count = count
+	l.expires = time.Now().Add(linkCountTTL)
+	return l.count, nil
+}
+
+// Added :: account for a newly created url without counting again.
+func (l *linkCounter) Added() {
+	l.mu.Lock()
+	l.count++
+	l.mu.Unlock()
+}
+
+// Invalidate :: forget the cached number, the next 'Count' counts again.
+func (l *linkCounter) Invalidate() {
+	l.mu.Lock()
+	l.expires = time.Time{}
+	l.mu.Unlock()
+}
+
+// LimitReached :: returns true if TLDR_MAX_LINKS is set and there are that many urls already.
+func (l *linkCounter) LimitReached() (bool, error) {
+	if conf.MaxLinks <= 0 {
+		return false, nil
+	}
+	count, err := l.Count()
+	if err != nil {
+		return false, err
+	}
+	return count >= int64(conf.MaxLinks), nil
+}
+
+// CountUrls :: returns the number of urls in the database that match the filter, its sort is ignored.
+func (d database) CountUrls(filter UrlFilter) (int64, error) {
+	var count int64
+
+	err := d.checkDb()
+	if err != nil {
+		return count, err
+	}
+
+	where, args := filter.where()
+	err = d.db.QueryRowContext(d.context(), `SELECT COUNT(*) FROM url`+where, args...).Scan(&count)
+	return count, err
+}
+
+// ShortsOfUrl :: the first (oldest) shorts that point to the target, at most limit of them.
+func (d database) ShortsOfUrl(target string, limit int) ([]string, error) {
+	query := `SELECT short FROM url WHERE url = ? ORDER BY ID LIMIT ?`
+	shorts := []string{}
+
+	err := d.checkDb()
+	if err != nil {
+		return shorts, err
+	}
+	// Compressed urls are stored gzipped, that is deterministic so the stored form can be compared directly.
+	stored, _, err := encodeTarget(target)
+	if err != nil {
+		return shorts, err
+	}
+
+	rows, err := d.db.QueryContext(d.context(), query, stored, limit)
+	if err != nil {
+		return shorts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var short string
+		if err = rows.Scan(&short); err != nil {
+			return shorts, err
+		}
+		shorts = append(shorts, short)
+	}
+	return shorts, rows.Err()
+}
+
+// checkAliasesPerUrl :: make sure the target doesn't have TLDR_MAX_ALIASES_PER_URL shorts already,
+// returns the response (listing the existing shorts to reuse) if it has.
+func (h handler) checkAliasesPerUrl(c *fiber.Ctx, target string) (Data, bool) {
+	if conf.MaxAliasesPerUrl == 0 {
+		return Data{}, true
+	}
+	shorts, err := h.dbFor(c).ShortsOfUrl(target, conf.MaxAliasesPerUrl)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return MakeResponse(500, err.Error(), Url{}), false
+	} else if len(shorts) >= conf.MaxAliasesPerUrl {
+		msg := fmt.Sprintf("URL has %d shorts already, reuse one of them", len(shorts))
+		data := MakeErrorResponse(409, "TOO_MANY_ALIASES", msg)
+		data.Shorts = shorts
+		return data, false
+	}
+	return Data{}, true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxLookupPad :: unknown shorts are never held back longer than this, a few slow lookups mustn't slow down every 404.
+const maxLookupPad = time.Second
+
+// lookupTimer :: keeps a moving average of how long resolving a known short takes, so unknown shorts can take as long
+// (TLDR_CONSTANT_TIME_LOOKUP). Otherwise a fast 404 tells which shorts exist even where the status doesn't, e.g. the
+// resolve of a protected short. This only evens out the timing, the status codes still differ.
+type lookupTimer struct {
+	mu      sync.Mutex
+	average time.Duration
+}
+
+// lookupTimes times 'resolveShort', existsTimes the cheaper 'ShortExists'.
+var (
+	lookupTimes = &lookupTimer{}
+	existsTimes = &lookupTimer{}
+)
+
+// Track :: account for a lookup that started at start and answered with status, known shorts (200, or 204 without a body)
+// feed the average and unknown ones wait for it.
+func (t *lookupTimer) Track(start time.Time, status *int) {
+	took := time.Since(start)
+	switch *status {
+	case 200, 204:
+		t.observe(took)
+	case 404:
+		if wait := t.Average() - took; wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+}
+
+// observe :: move the average towards the duration, every resolve weighs 1/16.
+func (t *lookupTimer) observe(took time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.average == 0 {
+		t.average = took
+		return
+	}
+	t.average += (took - t.average) / 16
+}
+
+// Average :: the average duration of a successful resolve, at most 'maxLookupPad'.
+func (t *lookupTimer) Average() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.average > maxLookupPad {
+		return maxLookupPad
+	}
+	return t.average
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLookupTimerPadsUnknownShorts(t *testing.T) {
+	timer := &lookupTimer{}
+	found := 204
+	timer.Track(time.Now().Add(-30*time.Millisecond), &found)
+
+	missing := 404
+	start := time.Now()
+	timer.Track(start, &missing)
+	if took := time.Since(start); took < 25*time.Millisecond {
+		t.Errorf("the 404 took %s, want about the 30ms of the known short", took)
+	}
+
+	// Other statuses don't touch the average and aren't held back.
+	invalid := 400
+	start = time.Now()
+	timer.Track(start.Add(-time.Second), &invalid)
+	if took := time.Since(start); took > 10*time.Millisecond || timer.Average() > 40*time.Millisecond {
+		t.Errorf("a 400 took %s and moved the average to %s", took, timer.Average())
+	}
+}
@@ -1,47 +1,209 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
-
-	uri "net/url"
+	"unicode"
 
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/favicon"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
 
 var (
-	once sync.Once
-	seed *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	once             sync.Once
+	seed             *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	idempotencyCache            = newIdempotencyStore(idempotencyRetention, idempotencyMaxKeys)
+	reservations                = newReservationStore(reservationTTL, maxReservations, maxClientReservations)
+	// cachedUrls is set up in main, once TLDR_CACHE_SIZE is known.
+	cachedUrls *urlCache
 )
 
 const (
 	charset      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	shortLength  = 18
 	databasePath = "data/tldr.db"
+
+	maxShortAttempts = 10
 )
 
+// ErrShortTaken :: 'InsertNewUrl' didn't insert anything, the short exists already.
+var ErrShortTaken = errors.New("short is already taken")
+
+// ErrEmptyShort :: a url without a short can never be looked up, it must not end up in the database.
+var ErrEmptyShort = errors.New("short must not be empty")
+
+// isEmptyShort :: returns true if the short (or its code, for a namespaced short) is empty.
+func isEmptyShort(short string) bool {
+	return short == "" || strings.HasSuffix(short, namespaceSeparator)
+}
+
+// ErrShortGeneration :: 'PrepareNewUrl' couldn't find a free short within 'maxShortAttempts' tries.
+var ErrShortGeneration = fmt.Errorf("no free short found after %d attempts", maxShortAttempts)
+
 type database struct {
 	db *sql.DB
+	// ctx bounds the queries, nil means they run without a deadline (see 'WithContext').
+	ctx context.Context
 }
 type Data struct {
-	Status  int
-	Message string
-	Data    Url
+	Status    int
+	Code      string `json:",omitempty"`
+	Message   string
+	RequestID string `json:",omitempty"`
+	// Created is only set when creating a url, false means an existing short was returned.
+	// In a dry run it tells whether the short would be created.
+	Created *bool `json:",omitempty"`
+	// FieldErrors tells what is wrong with the request body (INVALID_BODY).
+	FieldErrors []FieldError `json:",omitempty"`
+	// Shorts are the existing shorts of the url (TOO_MANY_ALIASES).
+	Shorts []string `json:",omitempty"`
+	Data   Url
 }
 type Url struct {
-	Url   string
-	Short string
-	Valid int
+	Url string
+	// OriginalUrl is the url exactly as it was submitted, only set if it differs from the normalized 'Url' redirects go to.
+	OriginalUrl string `json:",omitempty"`
+	Short       string
+	ShortUrl    string `json:",omitempty"`
+	// Valid is the status (see 'StatusActive'), Status its name.
+	Valid   int
+	Status  string
+	Uses    int
+	MaxUses int `json:",omitempty"`
+	Tags    []string
+	// Note is a free text description for the humans managing the url, it doesn't affect anything.
+	Note string `json:",omitempty"`
+	// FaviconUrl is resolved in the background after creation, if it was requested.
+	FaviconUrl string `json:",omitempty"`
+	// ActiveFrom is the time the url starts resolving, nil means right away.
+	ActiveFrom *time.Time `json:",omitempty"`
+	// CreatedAt is nil for urls that were created before it got recorded.
+	CreatedAt *time.Time `json:",omitempty"`
+	// ExpiresAt is the time the url stops resolving, nil means never.
+	ExpiresAt *time.Time `json:",omitempty"`
+	// Owner is the fingerprint of the api key the url was created with (see 'KeyOwner'), empty if it was created anonymously.
+	Owner string `json:",omitempty"`
+	// Wildcard urls are a prefix, the path after the short is appended to the url on redirect (see 'expandTarget').
+	Wildcard bool `json:",omitempty"`
+	// Protected is set if the url requires a password, the hash itself never leaves the server.
+	Protected    bool   `json:",omitempty"`
+	PasswordHash string `json:"-"`
+	// hideClicks leaves 'Uses' out of the json, see 'showClicks'.
+	hideClicks bool
+}
+
+// MarshalJSON :: the default encoding, without the 'Uses' field if the clicks are hidden.
+func (u Url) MarshalJSON() ([]byte, error) {
+	type plainUrl Url
+	if !u.hideClicks {
+		return json.Marshal(plainUrl(u))
+	}
+	// The outer field shadows the embedded one and is always omitted.
+	return json.Marshal(struct {
+		plainUrl
+		Uses *int `json:",omitempty"`
+	}{plainUrl: plainUrl(u)})
+}
+
+// urlColumns :: the columns that make up a 'Url', in the order 'scanUrl' expects them.
+const urlColumns = `url, short, valid, uses, max_uses, password_hash, tags, favicon_url, active_from, created_at, expires_at, owner, wildcard,
+	compressed, note, original_url`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUrl :: scan a row that was selected with 'urlColumns' into a 'Url'.
+func scanUrl(row scanner) (Url, error) {
+	var url Url
+	var maxUses sql.NullInt64
+	var passwordHash sql.NullString
+	var tags string
+	var faviconUrl sql.NullString
+	var activeFrom, createdAt, expiresAt sql.NullInt64
+	var owner sql.NullString
+	var target []byte
+	var compressed bool
+	var note, originalUrl sql.NullString
+
+	err := row.Scan(&target, &url.Short, &url.Valid, &url.Uses, &maxUses, &passwordHash, &tags, &faviconUrl,
+		&activeFrom, &createdAt, &expiresAt, &owner, &url.Wildcard, &compressed, &note, &originalUrl)
+	if err != nil {
+		return url, err
+	}
+	if url.Url, err = decodeTarget(target, compressed); err != nil {
+		return url, fmt.Errorf("could not decompress the url of '%s': %s", url.Short, err.Error())
+	}
+	url.Owner = owner.String
+	url.Note = note.String
+	url.OriginalUrl = originalUrl.String
+	url.Status = StatusName(url.Valid)
+	url.FaviconUrl = faviconUrl.String
+	url.ActiveFrom = timeFromNull(activeFrom)
+	url.CreatedAt = timeFromNull(createdAt)
+	url.ExpiresAt = timeFromNull(expiresAt)
+	url.MaxUses = int(maxUses.Int64)
+	url.Tags = splitTags(tags)
+	url.PasswordHash = passwordHash.String
+	url.Protected = url.PasswordHash != ""
+	return url, nil
+}
+
+// nullInt :: map 0 to NULL for nullable integer columns.
+func nullInt(i int) interface{} {
+	if i == 0 {
+		return nil
+	}
+	return i
+}
+
+// nullTime :: store times as unix timestamp, nil maps to NULL.
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+// timeFromNull :: the reverse of 'nullTime'.
+func timeFromNull(i sql.NullInt64) *time.Time {
+	if !i.Valid {
+		return nil
+	}
+	t := time.Unix(i.Int64, 0).UTC()
+	return &t
+}
+
+// nullString :: map "" to NULL for nullable text columns.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Payload :: same envelope as 'Data', for responses that carry something else than a 'Url'.
+type Payload struct {
+	Status    int
+	Code      string `json:",omitempty"`
+	Message   string
+	RequestID string `json:",omitempty"`
+	Data      interface{}
 }
 
 // MakeResponse :: make/build the response data, returns the 'Data' struct.
@@ -54,21 +216,78 @@ func MakeResponse(status int, message string, urlData Url) Data {
 	return data
 }
 
+// MakeErrorResponse :: same as 'MakeResponse' but also attaches a machine readable error code.
+func MakeErrorResponse(status int, code, message string) Data {
+	data := MakeResponse(status, message, Url{})
+	data.Code = code
+	return data
+}
+
+// SendResponse :: send the response data as json, the http status mirrors the status of the payload.
+// Routes behind 'textFormat' answer with text instead: the short url (see 'shortSnippet') on success, the message otherwise.
+func SendResponse(c *fiber.Ctx, data Data) error {
+	data.RequestID = RequestID(c)
+	data.Data.hideClicks = !showClicks(c)
+	setRetryAfter(c, data.Status)
+	if data.Code != "" {
+		c.Locals(errorCodeKey, data.Code)
+	}
+	if format, ok := c.Locals(textFormatKey).(string); ok {
+		c.Status(data.Status)
+		if data.Status == 200 {
+			c.Set(fiber.HeaderContentType, textFormats[format])
+			return c.SendString(shortSnippet(format, data.Data.ShortUrl) + "\n")
+		}
+		// Errors stay plain text in every format, there is nothing to link to.
+		c.Type("txt", "utf-8")
+		return c.SendString(data.Message + "\n")
+	}
+	return sendJSON(c, data.Status, data)
+}
+
+// SendPayload :: send any data in the response envelope, the http status mirrors the status of the payload.
+func SendPayload(c *fiber.Ctx, status int, message string, payload interface{}) error {
+	data := Payload{
+		Status:    status,
+		Message:   message,
+		RequestID: RequestID(c),
+		Data:      payload,
+	}
+	setRetryAfter(c, status)
+	return sendJSON(c, status, data)
+}
+
+// RequestID :: returns the id the requestid middleware assigned to the request (also sent as 'X-Request-Id' header).
+func RequestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("requestid").(string)
+	return id
+}
+
 // MakeUrl :: make/build the url data, returns the 'Url' struct with the provided data.
 func MakeUrl(url, short string, valid int) Url {
+	// Stored as unix timestamp, drop what doesn't survive the round trip.
+	now := time.Now().UTC().Truncate(time.Second)
 	tmpUrl := Url{
-		Url:   url,
-		Short: short,
-		Valid: valid,
+		Url:       url,
+		Short:     short,
+		Valid:     valid,
+		Status:    StatusName(valid),
+		Tags:      []string{},
+		CreatedAt: &now,
 	}
 	return tmpUrl
 }
 
+// seedMu :: guards seed, a rand.Rand isn't safe for concurrent use and shorts get created concurrently.
+var seedMu sync.Mutex
+
 // CreateRandomString ::
 func CreateRandomString(length int) string {
+	seedMu.Lock()
+	defer seedMu.Unlock()
 	b := make([]byte, length)
 	for i := range b {
-		b[i] = charset[seed.Intn(len(charset))]
+		b[i] = shortCharset()[seed.Intn(len(shortCharset()))]
 	}
 	return string(b)
 }
@@ -82,13 +301,17 @@ func (d database) checkDb() error {
 }
 
 // prepareDatabase :: initialize the database and create a database handle.
-//					  This funciton uses the sync.Once method, so the database gets created only once.
+// This funciton uses the sync.Once method, so the database gets created only once.
 func prepareDatabase() (database, error) {
 	var d database
 	var err error
 
+	// WAL lets readers work alongside a writer and the busy timeout makes concurrent writers wait for the lock
+	// instead of failing with 'database is locked'. Limiting the open connections (TLDR_DB_MAX_CONNS=1) serializes
+	// all access, which rules out lock errors entirely but also makes every request wait for the one before.
+	// SQLite ignores foreign keys unless every connection enables them, the clicks are deleted with their url through them.
 	prep := func() {
-		d.db, err = sql.Open("sqlite3", databasePath)
+		d, err = openDatabase(databasePath)
 		if err != nil {
 			log.Fatalf("Could not open %s", databasePath)
 		}
@@ -97,40 +320,205 @@ func prepareDatabase() (database, error) {
 	return d, err
 }
 
-// GetAllUrls :: as the function name says, retrieve ALL urls and return a map of 'urlRow' structs.
-func (d database) GetAllUrls() ([]Url, error) {
+// openDatabase :: open the sqlite database at path with the settings described in 'prepareDatabase'.
+func openDatabase(path string) (database, error) {
+	var d database
+	var err error
+
+	dsn := fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=%d&_foreign_keys=1", path, conf.DBBusyTimeout)
+	d.db, err = sql.Open("sqlite3", dsn)
+	if err != nil {
+		return d, err
+	}
+	d.db.SetMaxOpenConns(conf.DBMaxConns)
+	return d, nil
+}
+
+// UrlFilter :: narrows down the urls returned by 'GetAllUrls', zero values don't filter.
+type UrlFilter struct {
+	Tag string
+	// Valid filters by the valid flag when set, true only matches active urls.
+	Valid *bool
+	// Status filters by status when set.
+	Status *int
+	// Namespace only matches the shorts in this namespace.
+	Namespace string
+	// Owner only matches the urls of this owner (see 'KeyOwner').
+	Owner string
+	// Search matches urls whose short or note contains it, ignoring the case.
+	Search string
+	// CreatedAfter and CreatedBefore limit the creation time (inclusive), urls without one never match.
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+
+	// Sort is one of the 'sortColumns' (default created_at), Asc flips the default descending order.
+	Sort string
+	Asc  bool
+}
+
+// sortColumns :: the columns the url list can be sorted by, only these ever end up in the ORDER BY.
+var sortColumns = map[string]string{
+	"created_at": "created_at",
+	"clicks":     "uses",
+	"short":      "short",
+}
+
+// orderBy :: build the ORDER BY clause for the filter, unknown sort keys fall back to the default.
+// The ID breaks ties, e.g. between urls created before created_at got recorded.
+func (f UrlFilter) orderBy() string {
+	column, ok := sortColumns[f.Sort]
+	if !ok {
+		column = sortColumns["created_at"]
+	}
+	order := "DESC"
+	if f.Asc {
+		order = "ASC"
+	}
+	return fmt.Sprintf(` ORDER BY %s %s, ID %s`, column, order, order)
+}
+
+// where :: build the WHERE clause (and its arguments) for the filter, all values are passed as arguments.
+func (f UrlFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if f.Tag != "" {
+		conditions = append(conditions, `instr(tags, ?) > 0`)
+		args = append(args, ","+f.Tag+",")
+	}
+	if f.Valid != nil {
+		if *f.Valid {
+			conditions = append(conditions, `valid = ?`)
+		} else {
+			conditions = append(conditions, `valid != ?`)
+		}
+		args = append(args, StatusActive)
+	}
+	if f.Status != nil {
+		conditions = append(conditions, `valid = ?`)
+		args = append(args, *f.Status)
+	}
+	if f.Owner != "" {
+		conditions = append(conditions, `owner = ?`)
+		args = append(args, f.Owner)
+	}
+	if f.CreatedAfter != nil {
+		conditions = append(conditions, `created_at >= ?`)
+		args = append(args, f.CreatedAfter.Unix())
+	}
+	if f.CreatedBefore != nil {
+		conditions = append(conditions, `created_at <= ?`)
+		args = append(args, f.CreatedBefore.Unix())
+	}
+	if f.Search != "" {
+		conditions = append(conditions, `(instr(lower(short), ?) > 0 OR instr(lower(coalesce(note, '')), ?) > 0)`)
+		args = append(args, strings.ToLower(f.Search), strings.ToLower(f.Search))
+	}
+	if f.Namespace != "" {
+		prefix := Namespaced(f.Namespace, "")
+		conditions = append(conditions, `substr(short, 1, ?) = ?`)
+		args = append(args, len(prefix), prefix)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return ` WHERE ` + strings.Join(conditions, " AND "), args
+}
+
+// GetAllUrls :: as the function name says, retrieve ALL urls (matching the filter) and return a map of 'urlRow' structs.
+func (d database) GetAllUrls(filter UrlFilter) ([]Url, error) {
 	var url []Url
+	err := d.EachUrl(filter, func(tmp Url) error {
+		url = append(url, tmp)
+		return nil
+	})
+	return url, err
+}
 
+// EachUrl :: call fn for every url matching the filter while the rows are read, without loading all of them.
+// Stops at the first error fn returns.
+func (d database) EachUrl(filter UrlFilter, fn func(Url) error) error {
 	err := d.checkDb()
 	if err != nil {
-		return url, err
+		return err
 	}
 
-	query := `SELECT url, short, valid FROM url`
-	rows, err := d.db.Query(query)
+	where, args := filter.where()
+	query := `SELECT ` + urlColumns + ` FROM url` + where + filter.orderBy()
+	rows, err := d.db.QueryContext(d.context(), query, args...)
 	if err != nil {
-		return url, err
+		return err
 	}
+	defer rows.Close()
 
-	// Loop over all the returned data, prepare the struct, fill it with data and append it to the map.
 	for rows.Next() {
-		var tmp Url
-		err = rows.Scan(&tmp.Url, &tmp.Short, &tmp.Valid)
+		tmp, err := scanUrl(rows)
 		if err != nil {
 			log.Printf("ERROR: %s", err.Error())
-			return url, err
+			return err
+		}
+		if err = fn(tmp); err != nil {
+			return err
 		}
-		url = append(url, tmp)
 	}
 
-	return url, err
+	return rows.Err()
+}
+
+// ShortExists :: returns true if the short is taken, without loading the url.
+func (d database) ShortExists(short string) (bool, error) {
+	query := `SELECT 1 FROM url WHERE ` + shortEquals() + ` LIMIT 1`
+
+	err := d.checkDb()
+	if err != nil {
+		return false, err
+	}
+
+	var exists int
+	err = d.db.QueryRowContext(d.context(), query, short).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetUrlsByShorts :: look up many shorts with a single query, shorts that don't exist are missing in the map.
+// The map is keyed by 'canonicalShort'.
+func (d database) GetUrlsByShorts(shorts []string) (map[string]Url, error) {
+	urls := make(map[string]Url)
+	if len(shorts) == 0 {
+		return urls, nil
+	}
+
+	err := d.checkDb()
+	if err != nil {
+		return urls, err
+	}
+
+	in, args := shortsIn(shorts)
+	query := `SELECT ` + urlColumns + ` FROM url WHERE ` + in
+	rows, err := d.db.QueryContext(d.context(), query, args...)
+	if err != nil {
+		return urls, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		url, err := scanUrl(rows)
+		if err != nil {
+			return urls, err
+		}
+		urls[canonicalShort(url.Short)] = url
+	}
+	return urls, rows.Err()
 }
 
 // GetUrlFromShort :: this function resolves the `short` and returns the 'urlRow' struct filled with
-//					  the data from the database.
+// the data from the database.
 func (d database) GetUrlFromShort(urlShort string) (bool, Url, error) {
 	var url Url
-	query := `SELECT url, short, valid FROM url WHERE short=$1`
+	query := `SELECT ` + urlColumns + ` FROM url WHERE ` + shortEquals()
 
 	err := d.checkDb()
 	if err != nil {
@@ -138,8 +526,9 @@ func (d database) GetUrlFromShort(urlShort string) (bool, Url, error) {
 	}
 
 	// Query for a single row.
-	row := d.db.QueryRow(query, urlShort)
-	switch err := row.Scan(&url.Url, &url.Short, &url.Valid); err {
+	row := d.db.QueryRowContext(d.context(), query, urlShort)
+	url, err = scanUrl(row)
+	switch err {
 	case sql.ErrNoRows:
 		return false, url, nil
 	case nil:
@@ -150,55 +539,262 @@ func (d database) GetUrlFromShort(urlShort string) (bool, Url, error) {
 	}
 }
 
-// InsertNewUrl :: insert a new url into the database.
+// InsertNewUrl :: insert a new url into the database, returns 'ErrShortTaken' if the short already exists.
+// The unique index on short decides, so two concurrent inserts of the same short can't both succeed.
 func (d database) InsertNewUrl(url Url) error {
-	query := `INSERT INTO url (url, short, valid) VALUES (?, ?, ?)`
+	query := `INSERT INTO url (url, short, valid, max_uses, password_hash, tags, active_from, created_at, expires_at, owner, wildcard,
+			  compressed, note, original_url) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(short) DO NOTHING`
 
 	err := d.checkDb()
 	if err != nil {
 		return err
 	}
+	if isEmptyShort(url.Short) {
+		return ErrEmptyShort
+	}
+	target, compressed, err := encodeTarget(url.Url)
+	if err != nil {
+		return err
+	}
 
 	// Prepare the sql statement, this prevents sql injections.
-	sqlStmt, err := d.db.Prepare(query)
+	sqlStmt, err := d.db.PrepareContext(d.context(), query)
+	if err != nil {
+		return err
+	}
+	defer sqlStmt.Close()
+
+	// Execute the prepared statement, retry if the database is busy.
+	var affected int64
+	err = withRetry(func() error {
+		res, err := sqlStmt.ExecContext(d.context(), target, url.Short, url.Valid, nullInt(url.MaxUses), nullString(url.PasswordHash),
+			joinTags(url.Tags), nullTime(url.ActiveFrom), nullTime(url.CreatedAt), nullTime(url.ExpiresAt), nullString(url.Owner),
+			url.Wildcard, compressed, nullString(url.Note), nullString(url.OriginalUrl))
+		if err != nil {
+			return err
+		}
+		affected, err = res.RowsAffected()
+		return err
+	})
+	if err == nil && affected == 0 {
+		return ErrShortTaken
+	}
+	return err
+}
+
+// UpdateUrl :: change the target url (and the submitted original, see 'originalUrl') of the short, returns false if the short doesn't exist.
+func (d database) UpdateUrl(short, url, original string) (bool, error) {
+	query := `UPDATE url SET url = ?, compressed = ?, original_url = ? WHERE ` + shortEquals()
+
+	err := d.checkDb()
 	if err != nil {
+		return false, err
+	}
+	target, compressed, err := encodeTarget(url)
+	if err != nil {
+		return false, err
+	}
+
+	var affected int64
+	err = withRetry(func() error {
+		res, err := d.db.ExecContext(d.context(), query, target, compressed, nullString(original), short)
+		if err != nil {
+			return err
+		}
+		affected, err = res.RowsAffected()
 		return err
+	})
+	cachedUrls.Remove(short)
+	return affected == 1, err
+}
+
+// shortsIn :: the condition (and its arguments) matching any of the shorts, the IN counterpart of 'shortEquals'.
+func shortsIn(shorts []string) (string, []interface{}) {
+	args := make([]interface{}, len(shorts))
+	for i, short := range shorts {
+		args[i] = short
 	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(shorts)), ", ")
+	if conf.CaseInsensitive {
+		return `short COLLATE NOCASE IN (` + placeholders + `)`, args
+	}
+	return `short IN (` + placeholders + `)`, args
+}
+
+// deletedUrls :: the shorts of deleted urls by their owner, the owner of anonymous urls is empty.
+type deletedUrls map[string][]string
+
+// Count :: the number of deleted urls.
+func (d deletedUrls) Count() int64 {
+	var count int64
+	for _, shorts := range d {
+		count += int64(len(shorts))
+	}
+	return count
+}
 
-	// Execute the prepared statement.
-	_, err = sqlStmt.Exec(url.Url, url.Short, url.Valid)
+// deleteWhere :: delete the urls matching the condition (and their click history) in one transaction,
+// returns what was deleted.
+func (d database) deleteWhere(condition string, args []interface{}) (deletedUrls, error) {
+	deleted := deletedUrls{}
+
+	err := d.checkDb()
 	if err != nil {
+		return deleted, err
+	}
+
+	err = withRetry(func() error {
+		deleted = deletedUrls{}
+		tx, err := d.db.BeginTx(d.context(), nil)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(`SELECT short, coalesce(owner, '') FROM url WHERE `+condition, args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for rows.Next() {
+			var short, owner string
+			if err = rows.Scan(&short, &owner); err != nil {
+				rows.Close()
+				tx.Rollback()
+				return err
+			}
+			deleted[owner] = append(deleted[owner], short)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err = tx.Exec(`DELETE FROM url WHERE `+condition, args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
+	for _, shorts := range deleted {
+		cachedUrls.Remove(shorts...)
+	}
+	return deleted, err
+}
+
+// DeleteUrls :: delete the urls (their click history goes with them, see the foreign key of 'clicks_log'),
+// only those of owner if it is set. Shorts that don't exist are skipped.
+func (d database) DeleteUrls(shorts []string, owner string) (deletedUrls, error) {
+	if len(shorts) == 0 {
+		return deletedUrls{}, nil
+	}
+
+	in, args := shortsIn(shorts)
+	if owner != "" {
+		in += ` AND owner = ?`
+		args = append(args, owner)
+	}
+	return d.deleteWhere(in, args)
+}
+
+// DeleteAll :: delete every url (and with them every click), returns the number of deleted urls.
+func (d database) DeleteAll() (int64, error) {
+	err := d.checkDb()
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	err = withRetry(func() error {
+		res, err := d.db.ExecContext(d.context(), `DELETE FROM url`)
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
 		return err
+	})
+	cachedUrls.Clear()
+	return deleted, err
+}
+
+// UseUrl :: count a use of the short, once it reaches its max uses it gets invalidated.
+//
+// The check and the update happen in a single statement, so concurrent requests can't use a short more often than
+// allowed. Returns false if the short couldn't be used (anymore).
+func (d database) UseUrl(short string) (bool, error) {
+	query := `UPDATE url SET uses = uses + 1,
+				valid = CASE WHEN max_uses IS NOT NULL AND uses + 1 >= max_uses THEN ? ELSE valid END
+			  WHERE ` + shortEquals() + ` AND valid = ? AND (max_uses IS NULL OR uses < max_uses)`
+
+	err := d.checkDb()
+	if err != nil {
+		return false, err
 	}
 
-	return nil
+	var res sql.Result
+	err = withRetry(func() error {
+		res, err = d.db.ExecContext(d.context(), query, StatusDisabled, short, StatusActive)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
 }
 
 // PrepareNewUrl :: create a new short and make sure that it doesn't already exists.
-func (d database) PrepareNewUrl(url string) (Url, error) {
+func (d database) PrepareNewUrl(url, namespace string) (Url, error) {
+	return prepareNewUrl(d, url, namespace)
+}
+
+// shortStore :: the lookup 'prepareNewUrl' checks generated shorts against.
+type shortStore interface {
+	ShortExists(short string) (bool, error)
+}
+
+// prepareNewUrl :: see 'PrepareNewUrl', generates shorts until the store reports one as free.
+func prepareNewUrl(store shortStore, url, namespace string) (Url, error) {
 	var short string
 	var resp Url
 	ok := false
+	collisions := 0
 
 	// Generate a new short, make sure the short isn't already in use.
-	for !ok {
+	// Give up after a few collisions in a row instead of spinning forever.
+	for attempt := 0; !ok; attempt++ {
+		if attempt == maxShortAttempts {
+			recordGeneration(collisions)
+			return resp, ErrShortGeneration
+		}
 		tmpShort := CreateRandomString(shortLength)
-		valid, _, err := d.GetUrlFromShort(tmpShort)
+		if IsDeniedShort(tmpShort) {
+			continue
+		}
+		taken, err := store.ShortExists(Namespaced(namespace, tmpShort))
 		if err != nil {
 			return resp, err
-		} else if !valid {
-			short = tmpShort
+		} else if !taken {
+			short = Namespaced(namespace, tmpShort)
 			ok = true
+		} else {
+			collisions++
 		}
 	}
-	resp = MakeUrl(url, short, 1)
+	recordGeneration(collisions)
+	// Only a free short ends the loop, if that ever changes an empty short must not slip through.
+	if isEmptyShort(short) {
+		return resp, ErrEmptyShort
+	}
+	resp = MakeUrl(url, short, StatusActive)
 
 	return resp, nil
 }
 
 // IsValidHttpUrl :: make sure the provided url is a valid http address.
 func IsValidHttpUrl(url string) (bool, error) {
-	match, err := regexp.MatchString(`^http?://`, url)
+	match, err := regexp.MatchString(`(?i)^http://`, url)
 	if err != nil {
 		return false, err
 	}
@@ -210,7 +806,7 @@ func IsValidHttpUrl(url string) (bool, error) {
 
 // IsValidHttpsUrl :: make sure the provided url is a valid https address.
 func IsValidHttpsUrl(url string) (bool, error) {
-	match, err := regexp.MatchString(`^https?://`, url)
+	match, err := regexp.MatchString(`(?i)^https://`, url)
 	if err != nil {
 		return false, err
 	}
@@ -220,141 +816,163 @@ func IsValidHttpsUrl(url string) (bool, error) {
 	return true, nil
 }
 
-// IsValid :: returns true if url from provided struct is valid, else returns false.
-func IsValid(url Url) bool {
-	return url.Valid == 1
-}
+// schemeRegex :: a url scheme (RFC 3986), the rest of the url follows the colon.
+// Digits after the colon are a port instead, 'localhost:3000' or 'example.com:8080/x' don't have a scheme.
+var (
+	schemeRegex = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):(.*)$`)
+	portRegex   = regexp.MustCompile(`^[0-9]+([/?#]|$)`)
+)
 
-func main() {
-	db, err := prepareDatabase()
-	if err != nil {
-		panic(err)
+// UrlScheme :: returns the lowercase scheme of the url, empty if it doesn't have one.
+func UrlScheme(url string) string {
+	match := schemeRegex.FindStringSubmatch(url)
+	if match == nil || portRegex.MatchString(match[2]) {
+		return ""
 	}
-	app := fiber.New()
-
-	// Register middleware, precerve the requestID and also create a backend logger with a specific format.
-	app.Use(favicon.New())
-	app.Use(requestid.New())
-	app.Use(logger.New(logger.Config{
-		Format:     "${pid} - ${locals:requestid} :: [${status}] - ${method} - ${path}\n",
-		TimeFormat: "Jan-02-2006",
-		TimeZone:   "Europe/Vienna",
-	}))
+	return strings.ToLower(match[1])
+}
 
-	// Base /api/ route, returns ALL the available/registered routes/urls.
-	app.Get("/api/", func(c *fiber.Ctx) error {
-		urlMap, err := db.GetAllUrls()
-		if err != nil {
-			log.Printf("ERROR: %s", err.Error())
-			data := MakeResponse(500, err.Error(), Url{})
-			return c.JSON(data)
+// HasInvalidUrlChars :: returns true if the url contains whitespace or control characters.
+func HasInvalidUrlChars(url string) bool {
+	for _, r := range url {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return true
 		}
+	}
+	return false
+}
 
-		// Filter the db response and create a payload to send back.
-		var data []Data
-		for i := 0; i < len(urlMap); i++ {
-			var resp Data
-
-			url := MakeUrl(urlMap[i].Url, urlMap[i].Short, urlMap[i].Valid)
-			if IsValid(urlMap[i]) {
-				resp = MakeResponse(200, "Ok", url)
-			} else {
-				resp = MakeResponse(422, "URL is not valid", url)
-			}
-			data = append(data, resp)
-		}
+// IsValid :: returns true if url from provided struct is active, else returns false.
+func IsValid(url Url) bool {
+	return url.Valid == StatusActive
+}
 
-		return c.JSON(data)
-	})
+// IsActive :: returns true if the url already started resolving (see 'ActiveFrom').
+func IsActive(url Url) bool {
+	return url.ActiveFrom == nil || !url.ActiveFrom.After(time.Now())
+}
 
-	// Create new shorts, send a payload containing the url you want to be shortened.
-	// Post body example:
-	// {
-	//		"url": "example-domain.com"
-	// }
-	app.Post("/api/", func(c *fiber.Ctx) error {
-		var err error
-		var data Data
-		type urlPost struct {
-			Url string `json:"url"`
-		}
-		url := new(urlPost)
+// IsExpired :: returns true if the url has an expiry date and it passed.
+func IsExpired(url Url) bool {
+	return url.ExpiresAt != nil && !url.ExpiresAt.After(time.Now())
+}
 
-		// Parse the retrieved body content to the newly created struct.
-		if err = c.BodyParser(url); err != nil {
-			log.Printf("ERROR: %s", err.Error())
-			data = MakeResponse(500, err.Error(), Url{})
-			return c.JSON(data)
-		}
+// IsUsedUp :: returns true if the url has a limited number of uses and all of them are used.
+func IsUsedUp(url Url) bool {
+	return url.MaxUses > 0 && url.Uses >= url.MaxUses
+}
 
-		// Make sure that the provided url is an actuall url that can get redirected to (http|https).
-		https, err := IsValidHttpsUrl(url.Url)
-		if err != nil {
-			log.Printf("ERROR: %s", err.Error())
-		}
-		http, err := IsValidHttpUrl(url.Url)
-		if err != nil {
-			log.Printf("ERROR: %s", err.Error())
-		}
-		if !https && !http {
-			log.Printf("WARN: URL (%s) does not have a http* prefix, adding https:// to it", url.Url)
-			url.Url = "https://" + url.Url
-		}
-		// Check if it's parseable.
-		_, err = uri.ParseRequestURI(url.Url)
-		if err != nil {
-			log.Printf("ERROR: %s", err.Error())
-			data = MakeResponse(500, err.Error(), Url{})
-			return c.JSON(data)
-		}
+func main() {
+	migrateOnly := flag.Bool("migrate", false, "apply the database migrations and exit without starting the server")
+	flag.Parse()
+	conf = loadConfig()
+	limitEnrichment(conf.EnrichConcurrency)
+	cachedUrls = newUrlCache(conf.CacheSize)
 
-		// Prepare the new url for insertion.
-		prepUrl, err := db.PrepareNewUrl(url.Url)
+	db, err := prepareDatabase()
+	if err != nil {
+		panic(err)
+	}
+	if err = db.waitForDatabase(conf.DBConnectAttempts, conf.DBConnectBackoff); err != nil {
+		log.Fatalf("Could not connect to the database after %d attempts: %s", conf.DBConnectAttempts, err.Error())
+	}
+	if err = db.Migrate(); err != nil {
+		log.Fatalf("Could not migrate the database: %s", err.Error())
+	}
+	if conf.CaseInsensitive {
+		collision, err := db.CaseCollision()
 		if err != nil {
-			log.Printf("ERROR: %s", err.Error())
-			data = MakeResponse(500, err.Error(), Url{})
-			return c.JSON(data)
+			log.Fatalf("Could not check the shorts for case-insensitive mode: %s", err.Error())
+		} else if collision != "" {
+			log.Fatalf("TLDR_CASE_INSENSITIVE can't be enabled, the short '%s' exists in more than one casing", collision)
 		}
+	}
+	if *migrateOnly {
+		log.Printf("INFO: Database is up to date (%d migrations)", len(migrations))
+		return
+	}
+	if conf.Maintenance > 0 {
+		db.scheduleMaintenance(conf.Maintenance)
+	}
+	geo, err := newGeoLocator(conf.GeoIPDB)
+	if err != nil {
+		log.Fatalf("Could not open the GeoIP database: %s", err.Error())
+	}
+	app := newApp(db, geo)
 
-		// Insert the new url.
-		err = db.InsertNewUrl(prepUrl)
-		if err != nil {
-			log.Printf("ERROR: %s", err.Error())
-			data = MakeResponse(500, err.Error(), Url{})
-			return c.JSON(data)
-		}
+	if conf.TLSEnabled() {
+		log.Fatal(listenTLS(app, ":3000"))
+	}
+	log.Fatal(app.Listen(":3000"))
+}
 
-		// Send the 200 OK with the newly created url.
-		data = MakeResponse(200, "Ok", prepUrl)
-		return c.JSON(data)
+// newApp :: the server with all middleware and routes, configured by 'conf'.
+func newApp(db database, geo *geoLocator) *fiber.App {
+	clickLog := newClickLogger(db, geo)
+	// Forwarded headers (client ip, protocol, host) are only honored from trusted proxies, by default from nobody.
+	app := fiber.New(fiber.Config{
+		ErrorHandler:            errorHandler,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          conf.TrustedProxies,
+		ProxyHeader:             conf.ProxyHeader,
 	})
 
-	// This route get's invoked with a paramaeter (the short to unvail).
-	// It requests the given parameter (short url) and returns the redirect url.
-	app.Get("/api/*", func(c *fiber.Ctx) error {
-		var url Url
-		var param string
-		var data Data
+	// Register middleware, precerve the requestID and also create a backend logger with a specific format.
+	// Panics are recovered first, so they end up in the error handler no matter where they happen.
+	app.Use(recover.New(recover.Config{
+		EnableStackTrace:  true,
+		StackTraceHandler: logStackTrace,
+	}))
+	if conf.SecurityHeaders {
+		app.Use(securityHeaders)
+	}
+	if conf.Favicon {
+		app.Use(favicon.New())
+	}
+	app.Use(requestid.New())
+	app.Use(logger.New(logger.Config{
+		Next:       skipLog,
+		Format:     "${pid} - ${locals:requestid} :: [${status}] - ${method} - ${path}\n",
+		TimeFormat: conf.LogTimeFormat,
+		TimeZone:   conf.LogTimeZone,
+	}))
+	app.Use(requestTimeout(conf.Timeout))
+	app.Use(duringMaintenance)
+
+	h := handler{
+		db:         db,
+		clicks:     clickLog,
+		reputation: newReputationChecker(conf),
+		webhook:    newWebhook(conf, db),
+		links:      newLinkCounter(db),
+		events:     newEventBroker(),
+		abuse:      newAbuseGuard(conf),
+	}
+	app.Use(h.abuse.CheckBan)
+	if conf.ExpiryNotice > 0 {
+		scheduleExpiryNotices(db, h.webhook, conf.ExpiryNotice)
+	}
+
+	// The versioned api, v1 has to be registered first, otherwise the catch-all of the old routes swallows it.
+	// The old /api/ prefix stays around for existing clients and serves the exact same handlers.
+	// Everything lives below TLDR_BASE_PATH, which is empty unless the api is hosted under a sub path.
+	root := app.Group(conf.BasePath)
+	registerRoutes(root.Group("/api/v1"), h)
+	registerRoutes(root.Group("/api"), h)
+	root.Get("/s/*", h.Redirect)
+	// '/s/' arrives without its trailing slash, it gets the same MISSING_SHORT as '/api/.json' instead of a missing route.
+	root.Get("/s", h.Redirect)
+	registerProbes(root, db)
+	if conf.Debug {
+		log.Printf("WARN: TLDR_DEBUG is set, /debug/vars exposes runtime information")
+		registerDebug(root, db)
+	}
+	registerDocs(app, root)
 
-		param = c.Params("*")
-		found, url, err := db.GetUrlFromShort(param)
-		if err != nil {
-			log.Printf("ERROR: %s", err.Error())
-			data := MakeResponse(500, err.Error(), Url{})
-			return c.JSON(data)
-		} else if !found {
-			msg := fmt.Sprintf("No URL found for short '%s'.", param)
-			data := MakeResponse(404, msg, Url{})
-			return c.JSON(data)
-		}
-		// Make sure the URL is valid..
-		if !IsValid(url) {
-			data = MakeResponse(422, "URL is not valid", Url{})
-			return c.JSON(data)
-		}
-		data = MakeResponse(200, "Ok", url)
-		return c.JSON(data)
+	// Everything that didn't match a route ends up here, answer with the usual json instead of fiber's plain text.
+	app.Use(func(c *fiber.Ctx) error {
+		data := MakeResponse(404, "route not found", Url{})
+		return SendResponse(c, data)
 	})
-
-	log.Fatal(app.Listen(":3000"))
+	return app
 }
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testResponse :: the decoded envelope of a response, Data is left generic.
+type testResponse struct {
+	Status  int
+	Code    string
+	Message string
+	Created *bool
+	Data    map[string]interface{}
+}
+
+// newTestApp :: the app on a fresh database, configured by env on top of the defaults.
+// The globals the handlers share (cache, reservations, idempotency keys) are reset too.
+func newTestApp(t *testing.T, env map[string]string) (*fiber.App, database) {
+	t.Helper()
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	conf = loadConfig()
+	cachedUrls = newUrlCache(conf.CacheSize)
+	reservations = newReservationStore(reservationTTL, maxReservations, maxClientReservations)
+	idempotencyCache = newIdempotencyStore(idempotencyRetention, idempotencyMaxKeys)
+
+	db, err := openDatabase(filepath.Join(t.TempDir(), "tldr.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.db.Close() })
+	if err = db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return newApp(db, nil), db
+}
+
+// sendRaw :: make the request, body is sent as json if it isn't empty.
+// headers are pairs of name and value. Returns the response and its body.
+func sendRaw(t *testing.T, app *fiber.App, method, path, body string, headers ...string) (*http.Response, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, raw
+}
+
+// send :: like 'sendRaw', with the json envelope decoded.
+func send(t *testing.T, app *fiber.App, method, path, body string, headers ...string) (int, testResponse) {
+	t.Helper()
+	resp, raw := sendRaw(t, app, method, path, body, headers...)
+
+	var decoded testResponse
+	// Redirects and HEAD have no json body.
+	if len(raw) > 0 && strings.HasPrefix(resp.Header.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			t.Fatalf("%s %s: %s in %s", method, path, err.Error(), raw)
+		}
+	}
+	return resp.StatusCode, decoded
+}
+
+// listShorts :: the shorts 'GET /api/' lists for the request, and its status.
+func listShorts(t *testing.T, app *fiber.App, headers ...string) (int, []string) {
+	t.Helper()
+	resp, raw := sendRaw(t, app, "GET", "/api/", "", headers...)
+	if resp.StatusCode != 200 {
+		return resp.StatusCode, nil
+	}
+	var list []testResponse
+	if err := json.Unmarshal(raw, &list); err != nil {
+		t.Fatalf("GET /api/: %s in %s", err.Error(), raw)
+	}
+	var shorts []string
+	for _, entry := range list {
+		shorts = append(shorts, entry.Data["Short"].(string))
+	}
+	return resp.StatusCode, shorts
+}
+
+// create :: create a short with the body, fails the test unless it worked. Returns the short.
+func create(t *testing.T, app *fiber.App, body string, headers ...string) string {
+	t.Helper()
+	status, resp := send(t, app, "POST", "/api/", body, headers...)
+	if status != 200 {
+		t.Fatalf("creating %s: %d %s", body, status, resp.Message)
+	}
+	return resp.Data["Short"].(string)
+}
+
+func TestCreateUrlConcurrently(t *testing.T) {
+	app, db := newTestApp(t, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			status, resp := send(t, app, "POST", "/api/", fmt.Sprintf(`{"url": "https://example.com/%d"}`, i))
+			if status != 200 {
+				t.Errorf("create %d: %d %s", i, status, resp.Message)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	count, err := db.CountUrls(UrlFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 50 {
+		t.Errorf("%d urls were stored, want 50", count)
+	}
+}
+
+// redirectConcurrently :: open the short from many clients at once, returns how many got redirected.
+func redirectConcurrently(t *testing.T, app *fiber.App, short string, clients int) int {
+	t.Helper()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	redirects := 0
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _ := send(t, app, "GET", "/s/"+short, "")
+			mu.Lock()
+			defer mu.Unlock()
+			if status == fiber.StatusFound {
+				redirects++
+			}
+		}()
+	}
+	wg.Wait()
+	return redirects
+}
+
+func TestOneTimeUrlConcurrently(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	short := create(t, app, `{"url": "https://example.com/once", "one_time": true}`)
+
+	if redirects := redirectConcurrently(t, app, short, 30); redirects != 1 {
+		t.Errorf("the one-time short redirected %d times, want 1", redirects)
+	}
+	if status, _ := send(t, app, "GET", "/s/"+short, ""); status != fiber.StatusGone {
+		t.Errorf("the used one-time short answers %d, want 410", status)
+	}
+}
+
+func TestMaxClicksConcurrently(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	short := create(t, app, `{"url": "https://example.com/five", "max_clicks": 5}`)
+
+	if redirects := redirectConcurrently(t, app, short, 30); redirects != 5 {
+		t.Errorf("the short with max_clicks 5 redirected %d times", redirects)
+	}
+	if status, _ := send(t, app, "GET", "/s/"+short, ""); status != fiber.StatusGone {
+		t.Errorf("the used up short answers %d, want 410", status)
+	}
+}
+
+func TestInsertNewUrlConcurrently(t *testing.T) {
+	_, db := newTestApp(t, nil)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	inserted, taken := 0, 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := db.InsertNewUrl(Url{Url: "https://example.com", Short: "same", Valid: StatusActive})
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				inserted++
+			case ErrShortTaken:
+				taken++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if inserted != 1 || taken != 19 {
+		t.Errorf("%d inserts worked and %d found the short taken, want 1 and 19", inserted, taken)
+	}
+}
+
+// takenStore :: a store every short is taken in, it counts the lookups.
+type takenStore struct {
+	lookups int
+}
+
+func (s *takenStore) ShortExists(short string) (bool, error) {
+	s.lookups++
+	return true, nil
+}
+
+// fixedSeed :: make the generated shorts repeatable, every call starts the same sequence again.
+func fixedSeed(t *testing.T) {
+	t.Helper()
+	old := seed
+	seed = rand.New(rand.NewSource(1))
+	t.Cleanup(func() { seed = old })
+}
+
+func TestPrepareNewUrlGivesUp(t *testing.T) {
+	newTestApp(t, nil)
+	// None of the first shorts of this seed is denied, every attempt is a lookup.
+	fixedSeed(t)
+	store := &takenStore{}
+	url, err := prepareNewUrl(store, "https://example.com", "")
+	if err != ErrShortGeneration {
+		t.Fatalf("err = %v, want ErrShortGeneration", err)
+	}
+	if store.lookups != maxShortAttempts {
+		t.Errorf("%d lookups, want %d", store.lookups, maxShortAttempts)
+	}
+	if url.Short != "" || url.Url != "" {
+		t.Errorf("got a url %+v along with the error", url)
+	}
+}
+
+func TestCreateUrlShortGenerationFailed(t *testing.T) {
+	app, db := newTestApp(t, nil)
+	// Take every short the create will try.
+	fixedSeed(t)
+	for i := 0; i < maxShortAttempts; i++ {
+		short := CreateRandomString(shortLength)
+		if err := db.InsertNewUrl(Url{Short: short, Url: "https://example.com/" + short, Valid: StatusActive}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fixedSeed(t)
+
+	status, resp := send(t, app, "POST", "/api/", `{"url": "https://example.org"}`)
+	if status != 503 || resp.Code != "SHORT_GENERATION_FAILED" {
+		t.Errorf("create is %d %s, want 503 SHORT_GENERATION_FAILED", status, resp.Code)
+	}
+	if count, err := db.CountUrls(UrlFilter{}); err != nil || count != maxShortAttempts {
+		t.Errorf("%d urls (%v), want only the %d taken ones", count, err, maxShortAttempts)
+	}
+}
+
+// failingStore :: a store whose lookups fail.
+type failingStore struct{}
+
+func (failingStore) ShortExists(short string) (bool, error) {
+	return false, errors.New("database is locked")
+}
+
+func TestPrepareNewUrlStoreError(t *testing.T) {
+	newTestApp(t, nil)
+	url, err := prepareNewUrl(failingStore{}, "https://example.com", "")
+	if err == nil {
+		t.Fatal("the failed lookup got lost")
+	}
+	if url.Short != "" || url.Url != "" {
+		t.Errorf("got a half prepared url %+v along with the error", url)
+	}
+}
+
+func TestInsertNewUrlEmptyShort(t *testing.T) {
+	_, db := newTestApp(t, nil)
+	for _, short := range []string{"", "team1/"} {
+		if err := db.InsertNewUrl(Url{Short: short, Url: "https://example.com", Valid: StatusActive}); err != ErrEmptyShort {
+			t.Errorf("insert of %q: %v, want ErrEmptyShort", short, err)
+		}
+	}
+	if count, err := db.CountUrls(UrlFilter{}); err != nil || count != 0 {
+		t.Errorf("%d urls (%v) after inserting empty shorts", count, err)
+	}
+}
+
+func TestCreateUrlInsertError(t *testing.T) {
+	app, db := newTestApp(t, nil)
+	if _, err := db.db.Exec(`CREATE TRIGGER fail_insert BEFORE INSERT ON url BEGIN SELECT RAISE(ABORT, 'disk is full'); END`); err != nil {
+		t.Fatal(err)
+	}
+	status, resp := send(t, app, "POST", "/api/", `{"url": "https://example.com"}`)
+	if status != 500 {
+		t.Errorf("create with a failing insert is %d, want 500", status)
+	}
+	if resp.Data["Short"] != nil && resp.Data["Short"] != "" {
+		t.Errorf("the failed create answered with the short %v", resp.Data["Short"])
+	}
+	if count, err := db.CountUrls(UrlFilter{}); err != nil || count != 0 {
+		t.Errorf("%d urls (%v) after a failed insert", count, err)
+	}
+}
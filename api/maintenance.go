@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maintenanceTimeout :: how long 'Maintain' may take, VACUUM of a big database outlasts any request deadline.
+const maintenanceTimeout = 10 * time.Minute
+
+// ErrMaintenanceRunning :: 'Maintain' was called while another maintenance still runs.
+var ErrMaintenanceRunning = errors.New("the maintenance is running already")
+
+var (
+	// maintenanceRunning :: 1 while 'Maintain' runs, writes have to wait for VACUUM then.
+	maintenanceRunning int32
+	// maintenanceTook :: how long the last maintenance took (in nanoseconds), the estimate for 'Retry-After'.
+	maintenanceTook int64
+)
+
+// DatabaseSize :: size of the database files in bytes, the wal grows until it gets checkpointed.
+type DatabaseSize struct {
+	Database int64
+	WAL      int64
+}
+
+// MaintenanceResult :: the database size before and after 'Maintain'.
+type MaintenanceResult struct {
+	Before   DatabaseSize
+	After    DatabaseSize
+	Duration string
+}
+
+// databaseSize :: stat the database and its wal, missing files count as 0.
+func databaseSize() DatabaseSize {
+	var size DatabaseSize
+	if info, err := os.Stat(databasePath); err == nil {
+		size.Database = info.Size()
+	}
+	if info, err := os.Stat(databasePath + "-wal"); err == nil {
+		size.WAL = info.Size()
+	}
+	return size
+}
+
+// Maintain :: write the wal back into the database (and truncate it), then VACUUM to reclaim the free pages.
+// VACUUM locks the database while it runs, writes wait for it (up to the busy timeout).
+// Only one maintenance runs at a time, another call meanwhile fails with 'ErrMaintenanceRunning'.
+// The deadline of the request doesn't apply, a cancelled VACUUM would have to start over next time.
+func (d database) Maintain() (MaintenanceResult, error) {
+	var result MaintenanceResult
+
+	err := d.checkDb()
+	if err != nil {
+		return result, err
+	}
+
+	if !atomic.CompareAndSwapInt32(&maintenanceRunning, 0, 1) {
+		return result, ErrMaintenanceRunning
+	}
+	defer atomic.StoreInt32(&maintenanceRunning, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), maintenanceTimeout)
+	defer cancel()
+
+	start := time.Now()
+	result.Before = databaseSize()
+	for _, query := range []string{`PRAGMA wal_checkpoint(TRUNCATE)`, `VACUUM`} {
+		err = withRetry(func() error {
+			_, err := d.db.ExecContext(ctx, query)
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	// VACUUM goes through the wal as well, checkpoint again so the size reflects what was reclaimed.
+	if _, err = d.db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return result, err
+	}
+	result.After = databaseSize()
+	took := time.Since(start)
+	atomic.StoreInt64(&maintenanceTook, int64(took))
+	result.Duration = took.Round(time.Millisecond).String()
+	return result, nil
+}
+
+// duringMaintenance :: middleware that turns away writes with 503 while the maintenance runs, instead of letting
+// them wait for the database lock until they time out. Reads go through, the wal keeps them working.
+// 'Retry-After' is the duration of the last maintenance (rounded up), at least a second.
+func duringMaintenance(c *fiber.Ctx) error {
+	if atomic.LoadInt32(&maintenanceRunning) == 0 || c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+		return c.Next()
+	}
+	seconds := math.Ceil(time.Duration(atomic.LoadInt64(&maintenanceTook)).Seconds())
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Max(seconds, 1))))
+	data := MakeErrorResponse(503, "MAINTENANCE", "The database is being maintained, try again in a moment")
+	return SendResponse(c, data)
+}
+
+// scheduleMaintenance :: run 'Maintain' every interval in the background (TLDR_MAINTENANCE_INTERVAL_SECONDS).
+func (d database) scheduleMaintenance(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			result, err := d.Maintain()
+			if err != nil {
+				log.Printf("ERROR: Scheduled maintenance failed: %s", err.Error())
+				continue
+			}
+			log.Printf("INFO: Maintenance done in %s, database %d -> %d bytes", result.Duration,
+				result.Before.Database+result.Before.WAL, result.After.Database+result.After.WAL)
+		}
+	}()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaintainOnce(t *testing.T) {
+	_, db := newTestApp(t, nil)
+	atomic.StoreInt32(&maintenanceRunning, 1)
+	t.Cleanup(func() { atomic.StoreInt32(&maintenanceRunning, 0) })
+
+	if _, err := db.Maintain(); err != ErrMaintenanceRunning {
+		t.Fatalf("second maintenance: %v, want ErrMaintenanceRunning", err)
+	}
+	// The rejected call must not end the running maintenance.
+	if atomic.LoadInt32(&maintenanceRunning) != 1 {
+		t.Error("the rejected maintenance cleared the flag of the running one")
+	}
+
+	atomic.StoreInt32(&maintenanceRunning, 0)
+	if _, err := db.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&maintenanceRunning) != 0 {
+		t.Error("the maintenance is still marked as running")
+	}
+}
+
+func TestMaintainOutlivesRequest(t *testing.T) {
+	_, db := newTestApp(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.WithContext(ctx).Maintain(); err != nil {
+		t.Errorf("maintenance with a cancelled request: %v", err)
+	}
+}
+
+func TestDuringMaintenance(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	short := create(t, app, `{"url": "https://example.com"}`)
+	atomic.StoreInt32(&maintenanceRunning, 1)
+	// The last maintenance took no time, Retry-After still asks for a second.
+	atomic.StoreInt64(&maintenanceTook, 0)
+	t.Cleanup(func() {
+		atomic.StoreInt32(&maintenanceRunning, 0)
+		atomic.StoreInt64(&maintenanceTook, 0)
+	})
+
+	resp, body := sendRaw(t, app, "POST", "/api/", `{"url": "https://example.org"}`)
+	if resp.StatusCode != 503 || !strings.Contains(string(body), "MAINTENANCE") {
+		t.Errorf("write during maintenance: %d %s, want 503 MAINTENANCE", resp.StatusCode, body)
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || seconds < 1 {
+		t.Errorf("Retry-After is %q, want a number of seconds of at least 1", resp.Header.Get("Retry-After"))
+	}
+
+	atomic.StoreInt64(&maintenanceTook, int64(2500*time.Millisecond))
+	if resp, _ := sendRaw(t, app, "DELETE", "/api/", ""); resp.Header.Get("Retry-After") != "3" {
+		t.Errorf("Retry-After is %q after a maintenance of 2.5s, want 3", resp.Header.Get("Retry-After"))
+	}
+
+	for _, method := range []string{"GET", "HEAD"} {
+		if resp, _ := sendRaw(t, app, method, "/api/"+short, ""); resp.StatusCode != 200 && resp.StatusCode != 204 {
+			t.Errorf("%s during maintenance: %d, want it to go through", method, resp.StatusCode)
+		}
+	}
+}
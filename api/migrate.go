@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// migrations :: the schema, every statement is applied exactly once and in order.
+// The number of applied migrations is tracked in the database 'user_version', so only ever append to this list.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS "url" (
+		"ID"	INTEGER NOT NULL,
+		"url"	TEXT NOT NULL,
+		"short"	TEXT NOT NULL,
+		"valid"	INTEGER NOT NULL,
+		PRIMARY KEY("ID" AUTOINCREMENT)
+	)`,
+	`CREATE TABLE IF NOT EXISTS clicks_log (
+		short		TEXT NOT NULL,
+		accessed_at	INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS clicks_log_short_accessed_at ON clicks_log (short, accessed_at)`,
+	`ALTER TABLE url ADD COLUMN uses INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE url ADD COLUMN max_uses INTEGER`,
+	`ALTER TABLE clicks_log ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE clicks_log ADD COLUMN referer TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE url ADD COLUMN password_hash TEXT`,
+	`ALTER TABLE url ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE url ADD COLUMN favicon_url TEXT`,
+	`ALTER TABLE url ADD COLUMN active_from INTEGER`,
+	`ALTER TABLE url ADD COLUMN created_at INTEGER`,
+	`ALTER TABLE url ADD COLUMN expires_at INTEGER`,
+	`ALTER TABLE clicks_log ADD COLUMN country TEXT NOT NULL DEFAULT ''`,
+	// Fails if the database already contains duplicate shorts, those have to be cleaned up by hand.
+	`CREATE UNIQUE INDEX IF NOT EXISTS url_short ON url (short)`,
+	// 'valid' holds a status now, 1 (active) and 0 (disabled) keep their meaning, anything else was never written.
+	`UPDATE url SET valid = 0 WHERE valid NOT IN (0, 1)`,
+	`ALTER TABLE url ADD COLUMN owner TEXT`,
+	`ALTER TABLE url ADD COLUMN wildcard INTEGER NOT NULL DEFAULT 0`,
+	// Rows with compressed = 1 hold the gzipped url as blob, see 'encodeTarget'.
+	`ALTER TABLE url ADD COLUMN compressed INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE url ADD COLUMN note TEXT`,
+	// For 'ShortsOfUrl', TLDR_MAX_ALIASES_PER_URL looks the shorts of a url up on every create.
+	`CREATE INDEX IF NOT EXISTS url_url ON url (url)`,
+	// NULL if the submitted url didn't need normalizing, see 'originalUrl'.
+	`ALTER TABLE url ADD COLUMN original_url TEXT`,
+	// Set once the webhook was told that the url expires soon (TLDR_EXPIRY_NOTICE_SECONDS).
+	`ALTER TABLE url ADD COLUMN expiry_notified INTEGER NOT NULL DEFAULT 0`,
+	// Clicks belong to their url, deleting the url deletes them (the connection enables foreign keys).
+	// Clicks of urls that are gone already can't be linked to anything and get dropped.
+	`ALTER TABLE clicks_log ADD COLUMN url_id INTEGER REFERENCES url (ID) ON DELETE CASCADE`,
+	`UPDATE clicks_log SET url_id = (SELECT ID FROM url WHERE url.short = clicks_log.short)`,
+	`DELETE FROM clicks_log WHERE url_id IS NULL`,
+	`CREATE INDEX IF NOT EXISTS clicks_log_url_id ON clicks_log (url_id)`,
+	// Webhook deliveries that failed every attempt, see 'DeadLetters'.
+	`CREATE TABLE IF NOT EXISTS webhook_deadletter (
+		ID INTEGER PRIMARY KEY AUTOINCREMENT,
+		about TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		error TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		failed_at INTEGER NOT NULL
+	)`,
+}
+
+// Migrate :: bring the database schema up to date by applying all migrations that haven't been applied yet.
+func (d database) Migrate() error {
+	var version int
+
+	err := d.checkDb()
+	if err != nil {
+		return err
+	}
+
+	err = d.db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := d.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %s", i+1, err.Error())
+		}
+		// PRAGMA doesn't support placeholders, the version is an int so this is safe.
+		if _, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("INFO: Applied migration %d", i+1)
+	}
+
+	return nil
+}
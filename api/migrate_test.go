@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clickCount :: the number of rows in clicks_log, of one short if it is set.
+func clickCount(t *testing.T, db database, short string) int {
+	t.Helper()
+	var count int
+	if err := db.db.QueryRow(`SELECT count(*) FROM clicks_log WHERE ? = '' OR short = ?`, short, short).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestMigrateClicksCascade(t *testing.T) {
+	newTestApp(t, nil)
+	db, err := openDatabase(filepath.Join(t.TempDir(), "old.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.db.Close() })
+
+	// A database from before the clicks referenced their url.
+	all := migrations
+	before := 0
+	for before < len(all) && !strings.Contains(all[before], "url_id INTEGER REFERENCES") {
+		before++
+	}
+	migrations = all[:before]
+	err = db.Migrate()
+	migrations = all
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO url (url, short, valid) VALUES ('https://example.com/a', 'a', 1), ('https://example.com/b', 'b', 1)`,
+		`INSERT INTO clicks_log (short, accessed_at) VALUES ('a', 1), ('a', 2), ('b', 3), ('gone', 4)`,
+	} {
+		if _, err := db.db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if count := clickCount(t, db, "gone"); count != 0 {
+		t.Errorf("%d clicks of a deleted url survived the migration", count)
+	}
+	if count := clickCount(t, db, ""); count != 3 {
+		t.Errorf("%d clicks after the migration, want 3", count)
+	}
+
+	// Every connection of the pool has to enforce the foreign keys, not just the first one.
+	// Holding the connections makes the pool open new ones.
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := db.db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+		var enabled int
+		if err := conn.QueryRowContext(context.Background(), `PRAGMA foreign_keys`).Scan(&enabled); err != nil || enabled != 1 {
+			t.Fatalf("foreign_keys is %d (%v) on connection %d, want 1", enabled, err, i+1)
+		}
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	if _, err := db.DeleteUrls([]string{"a"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if count := clickCount(t, db, "a"); count != 0 {
+		t.Errorf("%d clicks left of the deleted url", count)
+	}
+	if count := clickCount(t, db, "b"); count != 1 {
+		t.Errorf("%d clicks of the other url, want 1", count)
+	}
+}
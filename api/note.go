@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxNoteLength = 500
+
+// NormalizeNote :: trim the note, returns an error if it is too long. An empty note means no note.
+func NormalizeNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > maxNoteLength {
+		return "", fmt.Errorf("note must be at most %d characters long", maxNoteLength)
+	}
+	return note, nil
+}
+
+// UpdateNote :: replace the note of the short, an empty note removes it. Returns false if the short doesn't exist.
+func (d database) UpdateNote(short, note string) (bool, error) {
+	query := `UPDATE url SET note = ? WHERE ` + shortEquals()
+
+	err := d.checkDb()
+	if err != nil {
+		return false, err
+	}
+
+	var affected int64
+	err = withRetry(func() error {
+		res, err := d.db.ExecContext(d.context(), query, nullString(note), short)
+		if err != nil {
+			return err
+		}
+		affected, err = res.RowsAffected()
+		return err
+	})
+	cachedUrls.Remove(short)
+	return affected == 1, err
+}
@@ -0,0 +1,754 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const openAPIPrefix = "/api/v1"
+
+// apiParam :: a query parameter of an api operation.
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// apiOperation :: documentation of a single route, the schemas reference 'openAPISchemas'.
+type apiOperation struct {
+	Summary     string
+	Auth        bool
+	Body        string
+	Query       []apiParam
+	Result      string
+	Responses   map[int]string
+	ContentType string
+}
+
+// listFilterParams :: the filters of the url list, see 'listFilter'.
+var listFilterParams = []apiParam{
+	{Name: "tag", Type: "string", Description: "Only urls with this tag"},
+	{Name: "namespace", Type: "string", Description: "Only urls in this namespace"},
+	{Name: "created_after", Type: "string", Description: "Only urls created at or after this time (RFC 3339 or unix seconds)"},
+	{Name: "created_before", Type: "string", Description: "Only urls created at or before this time (RFC 3339 or unix seconds)"},
+	{Name: "q", Type: "string", Description: "Only urls whose short or note contains this text (ignoring the case)"},
+	{Name: "valid", Type: "boolean", Description: "Only active (true) or not active (false) urls"},
+	{Name: "status", Type: "string", Description: "Only urls with this status: active, disabled, expired or flagged"},
+}
+
+// apiOperations :: documentation of the api routes, keyed by method and path relative to the api prefix.
+// Routes that are registered but missing here still show up in the spec, just without details.
+var apiOperations = map[string]apiOperation{
+	"GET /": {
+		Summary: "List all urls, needs an api key unless TLDR_LIST_PUBLIC is set",
+		Auth:    true,
+		Query: append(listFilterParams,
+			apiParam{Name: "sort", Type: "string", Description: "created_at (default), clicks or short"},
+			apiParam{Name: "order", Type: "string", Description: "asc or desc (default)"},
+		),
+		Result: "UrlList",
+		Responses: map[int]string{
+			200: "All urls, every item carries its own status",
+			400: "Invalid valid, status or created_* filter, sort or order",
+		},
+	},
+	"POST /": {
+		Summary: "Create a new short",
+		Body:    "CreateUrl",
+		Query: []apiParam{
+			{Name: "dry_run", Type: "boolean", Description: "Validate and normalize only, nothing is stored"},
+			{Name: "format", Type: "string", Description: "text answers with just the short url, markdown and html with a link to it (errors with the message as text/plain)"},
+		},
+		Result: "Data",
+		Responses: map[int]string{
+			200: "The created short, or the alias whose url was updated with TLDR_ALIAS_CONFLICT=update (Created is false)",
+			400: "Malformed body",
+			403: "The url was flagged by the reputation check (UNSAFE_URL) or TLDR_MAX_LINKS is reached (LINK_LIMIT_REACHED)",
+			409: "The alias is reserved (RESERVED_ALIAS), held by another reservation (ALIAS_HELD) or already taken (ALIAS_TAKEN, unless TLDR_ALIAS_CONFLICT=update and the api key owns it), or the url has TLDR_MAX_ALIASES_PER_URL shorts already (TOO_MANY_ALIASES, they are listed in Shorts), or a request with the same Idempotency-Key is still running (IDEMPOTENCY_IN_PROGRESS)",
+			415: "The body is not json",
+			422: "The body has unknown fields or wrong types (INVALID_BODY), the url is empty, invalid, not http(s) (UNSUPPORTED_SCHEME), plain http with TLDR_REQUIRE_HTTPS (HTTPS_REQUIRED) a short link (ALREADY_SHORTENED) or not reachable with TLDR_VERIFY_ON_CREATE (UNREACHABLE_TARGET), max_clicks is negative, the expiry is invalid, or the alias/namespace contains invalid characters (INVALID_ALIAS, INVALID_NAMESPACE) or a denied word (DENIED_ALIAS, DENIED_NAMESPACE)",
+			503: "The reputation check failed and TLDR_REPUTATION_FAIL_OPEN is off (REPUTATION_UNAVAILABLE), or no free short was found (SHORT_GENERATION_FAILED)",
+		},
+	},
+	"DELETE /": {
+		Summary: "Delete all urls, needs an admin key",
+		Auth:    true,
+		Query:   []apiParam{{Name: "confirm", Type: "boolean", Description: "Has to be true"}},
+		Result:  "DeleteResult",
+		Responses: map[int]string{
+			200: "The number of deleted urls",
+			400: "Missing confirmation",
+			403: "The api key is not an admin key (ADMIN_REQUIRED)",
+		},
+	},
+	"POST /resolve": {
+		Summary: "Look up many shorts at once, without counting a use",
+		Body:    "ResolveShorts",
+		Result:  "Resolutions",
+		Responses: map[int]string{
+			200: "Every requested short, unknown ones and ones without a valid signature are marked as not found",
+			400: "Malformed body",
+			415: "The body is not json",
+			422: "Too many shorts (at most 100)",
+		},
+	},
+	"POST /stats/batch": {
+		Summary: "Clicks, latest click and validity of many shorts at once, needs an api key unless TLDR_EXPOSE_CLICKS is set",
+		Auth:    true,
+		Body:    "ResolveShorts",
+		Result:  "BatchStats",
+		Responses: map[int]string{
+			200: "Every requested short, unknown ones and ones without a valid signature are marked as not found",
+			400: "Malformed body",
+			415: "The body is not json",
+			422: "Too many shorts (at most 100)",
+		},
+	},
+	"POST /reserve": {
+		Summary: "Hold an available alias for 5 minutes, create it with the returned token as reservation",
+		Body:    "ReserveAlias",
+		Result:  "Reservation",
+		Responses: map[int]string{
+			201: "The alias is held for the token",
+			400: "Malformed body",
+			409: "The alias is reserved (RESERVED_ALIAS), held by somebody else (ALIAS_HELD) or already taken (ALIAS_TAKEN)",
+			415: "The body is not json",
+			422: "The alias/namespace contains invalid characters or a denied word",
+			429: "The client holds 20 reservations already (TOO_MANY_RESERVATIONS)",
+			503: "Too many aliases are held right now (RESERVATIONS_FULL)",
+		},
+	},
+	"GET /export": {
+		Summary:     "Export all urls as newline delimited json (one Url per line, oldest first), needs an api key unless TLDR_LIST_PUBLIC is set",
+		Auth:        true,
+		Query:       []apiParam{{Name: "format", Type: "string", Description: "ndjson (default and only format)"}},
+		Result:      "Url",
+		ContentType: "application/x-ndjson",
+		Responses: map[int]string{
+			200: "The urls, streamed",
+			400: "Unknown format",
+		},
+	},
+	"GET /random": {
+		Summary: "Pick a random url that resolves right now (not protected), keys that aren't admin keys only get their own urls",
+		Auth:    true,
+		Query:   []apiParam{{Name: "redirect", Type: "boolean", Description: "Redirect (302) to the short url instead of returning the json"}},
+		Result:  "Data",
+		Responses: map[int]string{
+			200: "The picked url",
+			302: "Redirect to the short url of the picked url",
+			400: "redirect is not a boolean",
+			404: "No url is available",
+		},
+	},
+	"GET /count": {
+		Summary: "Count the urls the list would return with the same filters, keys that aren't admin keys only count their own",
+		Auth:    true,
+		Query:   listFilterParams,
+		Result:  "Count",
+		Responses: map[int]string{
+			200: "The number of matching urls",
+			400: "Invalid valid, status or created_* filter",
+		},
+	},
+	"GET /events": {
+		Summary:     "Stream created and deleted urls as server-sent events, keys that aren't admin keys only see their own new urls",
+		Auth:        true,
+		Result:      "LinkEvent",
+		ContentType: "text/event-stream",
+		Responses: map[int]string{
+			200: "The events, streamed until the client disconnects",
+		},
+	},
+	"POST /delete": {
+		Summary: "Delete the urls of up to 500 shorts at once, keys that aren't admin keys only delete their own",
+		Auth:    true,
+		Body:    "ResolveShorts",
+		Result:  "DeleteResult",
+		Responses: map[int]string{
+			200: "Number of deleted urls, unknown shorts don't count",
+			400: "Malformed body",
+			415: "The body is not json",
+			422: "Too many shorts",
+		},
+	},
+	"POST /purge": {
+		Summary: "Delete all expired and/or disabled (including used up) urls, keys that aren't admin keys only purge their own, flagged urls stay",
+		Auth:    true,
+		Query:   []apiParam{{Name: "scope", Type: "string", Description: "expired, invalid (disabled or used up) or both, required"}},
+		Result:  "DeleteResult",
+		Responses: map[int]string{
+			200: "The number of deleted urls",
+			400: "Missing or unknown scope",
+		},
+	},
+	"POST /maintenance": {
+		Summary: "Checkpoint the wal and VACUUM the database",
+		Auth:    true,
+		Result:  "Maintenance",
+		Responses: map[int]string{
+			200: "Database size before and after",
+			409: "Another maintenance is still running (MAINTENANCE_RUNNING)",
+			503: "The maintenance is running, like every other write (MAINTENANCE)",
+		},
+	},
+	"GET /webhooks/deadletter": {
+		Summary: "List the latest 100 webhook deliveries that failed every attempt, needs an admin key",
+		Auth:    true,
+		Result:  "DeadLetters",
+		Responses: map[int]string{
+			200: "The dead letters, newest first",
+			403: "The api key is not an admin key (ADMIN_REQUIRED)",
+		},
+	},
+	"POST /webhooks/deadletter/{id}/retry": {
+		Summary: "Post a dead letter to the webhook again (once), it is deleted once delivered, needs an admin key",
+		Auth:    true,
+		Result:  "DeadLetter",
+		Responses: map[int]string{
+			200: "The delivered dead letter",
+			400: "The id is not a number",
+			403: "The api key is not an admin key (ADMIN_REQUIRED)",
+			404: "Unknown dead letter",
+			502: "The delivery failed again (WEBHOOK_FAILED), the dead letter stays",
+			503: "TLDR_WEBHOOK_URL is not set (WEBHOOK_DISABLED)",
+		},
+	},
+	"DELETE /webhooks/deadletter/{id}": {
+		Summary: "Drop a dead letter without delivering it, needs an admin key",
+		Auth:    true,
+		Result:  "DeadLetter",
+		Responses: map[int]string{
+			200: "The deleted dead letter",
+			400: "The id is not a number",
+			403: "The api key is not an admin key (ADMIN_REQUIRED)",
+			404: "Unknown dead letter",
+		},
+	},
+	"GET /{short}/analytics.csv": {
+		Summary:     "Download the click log of the short as csv (accessed_at, user_agent, referer, country), user_agent and referer only while TLDR_LOG_PII is enabled",
+		Auth:        true,
+		Result:      "Csv",
+		ContentType: "text/csv",
+		Responses: map[int]string{
+			200: "The clicks, oldest first, just the header row if there are none",
+			404: "Unknown short, or one of another owner",
+		},
+	},
+	"GET /{short}/barcode": {
+		Summary: "The short url as code 128 barcode, for printed labels and linear scanners",
+		Query: []apiParam{
+			{Name: "scale", Type: "integer", Description: "Width of the narrowest bar in pixels, 1 to 10 (default 2)"},
+			{Name: "height", Type: "integer", Description: "Height in pixels, 10 to 1000 (default 80)"},
+		},
+		Result:      "Png",
+		ContentType: "image/png",
+		Responses: map[int]string{
+			200: "The barcode",
+			400: "Invalid short, scale or height",
+			403: "The signature of the short is missing or wrong (INVALID_SIGNATURE)",
+			404: "Unknown short",
+			422: "The short url has characters a code 128 barcode can't hold (non-ascii TLDR_BASE_URL)",
+		},
+	},
+	"PUT /alias/{alias}": {
+		Summary: "Create the alias for the url, or point the existing alias to it (idempotent)",
+		Auth:    true,
+		Body:    "PutAlias",
+		Result:  "Data",
+		Responses: map[int]string{
+			200: "The alias existed, its url is the requested one now",
+			201: "The alias was created",
+			403: "The url is unsafe (UNSAFE_URL) or the link limit is reached (LINK_LIMIT_REACHED)",
+			409: "The alias is reserved (RESERVED_ALIAS), held by a reservation (ALIAS_HELD), owned by another key or was created concurrently (ALIAS_TAKEN)",
+			415: "The body is not json",
+			422: "The url is empty, invalid or not http(s), plain http with TLDR_REQUIRE_HTTPS (HTTPS_REQUIRED), or the alias is invalid (INVALID_ALIAS, DENIED_ALIAS)",
+		},
+	},
+	"PUT /{short}": {
+		Summary: "Change the target url of a short",
+		Auth:    true,
+		Body:    "PutUrl",
+		Result:  "Data",
+		Responses: map[int]string{
+			200: "The updated short",
+			400: "Malformed body or invalid short (INVALID_SHORT)",
+			403: "The url was flagged by the reputation check (UNSAFE_URL)",
+			404: "Unknown short, or owned by another key that isn't an admin key",
+			409: "The url has TLDR_MAX_ALIASES_PER_URL shorts already (TOO_MANY_ALIASES, they are listed in Shorts)",
+			415: "The body is not json",
+			422: "The url is empty, invalid or not http(s) (UNSUPPORTED_SCHEME), plain http with TLDR_REQUIRE_HTTPS (HTTPS_REQUIRED)",
+		},
+	},
+	"PATCH /{short}": {
+		Summary: "Change the tags or the status of a short",
+		Auth:    true,
+		Body:    "PatchUrl",
+		Result:  "Data",
+		Responses: map[int]string{
+			200: "The updated short",
+			400: "Malformed body or invalid short (INVALID_SHORT)",
+			404: "Unknown short, or owned by another key that isn't an admin key",
+			415: "The body is not json",
+			422: "Invalid tags or status",
+		},
+	},
+	"GET /{short}/stats": {
+		Summary: "Click statistics of a short, needs an api key unless TLDR_EXPOSE_CLICKS is set",
+		Auth:    true,
+		Query:   []apiParam{{Name: "days", Type: "integer", Description: "Days to look back, 1-365 (default 30)"}},
+		Result:  "ClickStats",
+		Responses: map[int]string{
+			200: "Clicks per day, top referrers and clicks per country",
+			400: "Invalid days or short (INVALID_SHORT)",
+			404: "Unknown short, or owned by another key that isn't an admin key",
+		},
+	},
+	"GET /{short}/exists": {
+		Summary: "Check if a short is taken, without counting a use",
+		Responses: map[int]string{
+			204: "The short exists",
+			400: "The short contains invalid characters",
+			404: "The short doesn't exist, or its signature is missing or wrong (TLDR_SIGNING_SECRET)",
+		},
+	},
+	"GET /{short}/redirect-chain": {
+		Summary: "Request the target of a short and report where it ends up, private addresses count as unreachable",
+		Auth:    true,
+		Query:   []apiParam{{Name: "password", Type: "string", Description: "Password of a protected short (or X-Link-Password header)"}},
+		Result:  "TargetCheck",
+		Responses: map[int]string{
+			200: "Status and final url of the target, or why it couldn't be reached",
+			400: "The short contains invalid characters (INVALID_SHORT)",
+			401: "The short is password protected (PASSWORD_REQUIRED)",
+			404: "Unknown short",
+		},
+	},
+	"GET /{short}": {
+		Summary: "Resolve a short, '{short}.txt' answers with just the url as text/plain ('.json' is the default)",
+		Query:   []apiParam{{Name: "password", Type: "string", Description: "Password of a protected short (or X-Link-Password header)"}},
+		Result:  "Data",
+		Responses: map[int]string{
+			200: "The url to redirect to, also sent as Location header",
+			400: "The short is missing, e.g. for '.json' alone (MISSING_SHORT), or contains invalid characters (INVALID_SHORT)",
+			401: "The short is password protected (PASSWORD_REQUIRED)",
+			403: "The short is not active yet (NOT_YET_ACTIVE), flagged (FLAGGED) or its signature is missing or wrong (INVALID_SIGNATURE)",
+			404: "Unknown short",
+			410: "The short is used up or expired (EXPIRED)",
+			422: "The url is disabled",
+		},
+	},
+}
+
+// publicOperations :: documentation of the routes outside of the api, keyed by method and absolute path.
+var publicOperations = map[string]apiOperation{
+	"GET /livez": {
+		Summary:   "Liveness probe, answers as long as the process is up",
+		Responses: map[int]string{200: "The process is up"},
+	},
+	"GET /readyz": {
+		Summary: "Readiness probe, checks the database and its schema, answers with the short generation numbers",
+		Result:  "Readiness",
+		Responses: map[int]string{
+			200: "Ready",
+			503: "The database is unreachable or not migrated",
+		},
+	},
+	"GET /s/{short}": {
+		Summary: "Redirect to the url of a short, the path after a wildcard short is appended to its url ('/s/{short}/more/path')",
+		Query:   []apiParam{{Name: "password", Type: "string", Description: "Password of a protected short (or X-Link-Password header)"}},
+		Responses: map[int]string{
+			302: "Redirect to the url",
+			400: "The short is missing (MISSING_SHORT) or contains invalid characters (INVALID_SHORT)",
+			401: "The short is password protected (PASSWORD_REQUIRED)",
+			403: "The short is not active yet (NOT_YET_ACTIVE), flagged (FLAGGED) or its signature is missing or wrong (INVALID_SIGNATURE)",
+			404: "Unknown short",
+			410: "The short is used up or expired (EXPIRED)",
+			422: "The url is disabled",
+		},
+	},
+}
+
+// deadLetterSchema :: a failed webhook delivery, see 'DeadLetter'.
+var deadLetterSchema = object(map[string]interface{}{
+	"ID":       schema("integer"),
+	"About":    schema("string"),
+	"Payload":  map[string]interface{}{"type": "object"},
+	"Error":    schema("string"),
+	"Attempts": schema("integer"),
+	"FailedAt": map[string]interface{}{"type": "string", "format": "date-time"},
+})
+
+// openAPISchemas :: the json schemas of request and response bodies.
+var openAPISchemas = map[string]interface{}{
+	"Url": object(map[string]interface{}{
+		"Url":         schema("string"),
+		"OriginalUrl": schema("string"),
+		"Short":       schema("string"),
+		"ShortUrl":    schema("string"),
+		"Valid":       schema("integer"),
+		"Status":      map[string]interface{}{"type": "string", "enum": []string{"active", "disabled", "expired", "flagged"}},
+		"Uses":        schema("integer"),
+		"MaxUses":     schema("integer"),
+		"Protected":   schema("boolean"),
+		"Tags":        map[string]interface{}{"type": "array", "items": schema("string")},
+		"Note":        schema("string"),
+		"FaviconUrl":  schema("string"),
+		"CreatedAt":   map[string]interface{}{"type": "string", "format": "date-time"},
+		"ExpiresAt":   map[string]interface{}{"type": "string", "format": "date-time"},
+		"ActiveFrom":  map[string]interface{}{"type": "string", "format": "date-time"},
+		"Wildcard":    schema("boolean"),
+		"Owner":       schema("string"),
+	}),
+	"Data":     envelope(ref("Url")),
+	"UrlList":  map[string]interface{}{"type": "array", "items": ref("Data")},
+	"Error":    envelope(ref("Url")),
+	"Envelope": envelope(map[string]interface{}{}),
+	"CreateUrl": object(map[string]interface{}{
+		"url":            schema("string"),
+		"alias":          schema("string"),
+		"namespace":      schema("string"),
+		"wildcard":       schema("boolean"),
+		"one_time":       schema("boolean"),
+		"max_clicks":     schema("integer"),
+		"password":       schema("string"),
+		"tags":           map[string]interface{}{"type": "array", "items": schema("string")},
+		"favicon":        schema("boolean"),
+		"active_from":    map[string]interface{}{"type": "string", "format": "date-time"},
+		"strip_tracking": schema("boolean"),
+		"dry_run":        schema("boolean"),
+		"expires_at":     map[string]interface{}{"type": "string", "format": "date-time"},
+		"ttl_seconds":    schema("integer"),
+		"permanent":      schema("boolean"),
+		"reservation":    schema("string"),
+		"note":           schema("string"),
+	}),
+	"ReserveAlias": object(map[string]interface{}{
+		"alias":       schema("string"),
+		"namespace":   schema("string"),
+		"reservation": schema("string"),
+	}),
+	"Reservation": envelope(object(map[string]interface{}{
+		"Short":     schema("string"),
+		"Token":     schema("string"),
+		"ExpiresAt": map[string]interface{}{"type": "string", "format": "date-time"},
+	})),
+	"PutUrl": object(map[string]interface{}{
+		"url":  schema("string"),
+		"note": schema("string"),
+	}),
+	"PutAlias": object(map[string]interface{}{
+		"url": schema("string"),
+	}),
+	"ResolveShorts": object(map[string]interface{}{
+		"shorts": map[string]interface{}{"type": "array", "items": schema("string")},
+	}),
+	"Resolutions": envelope(map[string]interface{}{
+		"type": "object",
+		"additionalProperties": object(map[string]interface{}{
+			"Url":       schema("string"),
+			"Valid":     schema("boolean"),
+			"Found":     schema("boolean"),
+			"Protected": schema("boolean"),
+		}),
+	}),
+	"BatchStats": envelope(map[string]interface{}{
+		"type": "object",
+		"additionalProperties": object(map[string]interface{}{
+			"Found":        schema("boolean"),
+			"Clicks":       schema("integer"),
+			"LastAccessed": map[string]interface{}{"type": "string", "format": "date-time"},
+			"Valid":        schema("boolean"),
+		}),
+	}),
+	"TargetCheck": envelope(object(map[string]interface{}{
+		"Short":            schema("string"),
+		"Url":              schema("string"),
+		"Reachable":        schema("boolean"),
+		"Status":           schema("integer"),
+		"FinalUrl":         schema("string"),
+		"Error":            schema("string"),
+		"TooManyRedirects": schema("boolean"),
+	})),
+	"DeadLetters": envelope(map[string]interface{}{"type": "array", "items": deadLetterSchema}),
+	"DeadLetter":  envelope(deadLetterSchema),
+	"Maintenance": envelope(object(map[string]interface{}{
+		"Before":   object(map[string]interface{}{"Database": schema("integer"), "WAL": schema("integer")}),
+		"After":    object(map[string]interface{}{"Database": schema("integer"), "WAL": schema("integer")}),
+		"Duration": schema("string"),
+	})),
+	"PatchUrl": object(map[string]interface{}{
+		"tags":   map[string]interface{}{"type": "array", "items": schema("string")},
+		"status": map[string]interface{}{"type": "string", "enum": []string{"active", "disabled", "expired", "flagged"}},
+		"note":   schema("string"),
+	}),
+	"LinkEvent": object(map[string]interface{}{
+		"Event":  map[string]interface{}{"type": "string", "enum": []string{"created", "deleted"}},
+		"Short":  schema("string"),
+		"Url":    schema("string"),
+		"Shorts": map[string]interface{}{"type": "array", "items": schema("string")},
+		"Count":  schema("integer"),
+		"At":     map[string]interface{}{"type": "string", "format": "date-time"},
+	}),
+	"Readiness": envelope(object(map[string]interface{}{
+		"ShortGeneration": object(map[string]interface{}{
+			"Generations":   schema("integer"),
+			"Collisions":    schema("integer"),
+			"CollisionRate": schema("number"),
+		}),
+	})),
+	"Csv":          schema("string"),
+	"Png":          map[string]interface{}{"type": "string", "format": "binary"},
+	"Count":        envelope(object(map[string]interface{}{"Count": schema("integer")})),
+	"DeleteResult": envelope(object(map[string]interface{}{"Deleted": schema("integer")})),
+	"ClickStats": envelope(object(map[string]interface{}{
+		"Short": schema("string"),
+		"Days": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"Date":  schema("string"),
+			"Count": schema("integer"),
+		})},
+		"TopReferrers": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"Referer": schema("string"),
+			"Count":   schema("integer"),
+		})},
+		"Countries": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"Country": schema("string"),
+			"Count":   schema("integer"),
+		})},
+	})),
+}
+
+// schema :: a schema of a plain json type.
+func schema(t string) map[string]interface{} {
+	return map[string]interface{}{"type": t}
+}
+
+// ref :: reference to one of the 'openAPISchemas'.
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// object :: a schema of a json object with the given properties.
+func object(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// envelope :: the response envelope every route answers with, 'data' is the schema of the payload.
+func envelope(data map[string]interface{}) map[string]interface{} {
+	return object(map[string]interface{}{
+		"Status":    schema("integer"),
+		"Code":      schema("string"),
+		"Message":   schema("string"),
+		"RequestID": schema("string"),
+		"Created":   schema("boolean"),
+		"FieldErrors": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"Field":   schema("string"),
+			"Message": schema("string"),
+		})},
+		"Shorts": map[string]interface{}{"type": "array", "items": schema("string")},
+		"Data":   data,
+	})
+}
+
+// openAPIPath :: turn a fiber route path into an openapi path template, e.g. '/:short/stats' -> '/{short}/stats'.
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + strings.TrimSuffix(strings.TrimPrefix(segment, ":"), "?") + "}"
+		} else if segment == "*" {
+			segments[i] = "{short}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// openAPIOperation :: build the openapi operation object for a documented (or undocumented) route.
+func openAPIOperation(path string, op apiOperation, documented bool) map[string]interface{} {
+	operation := map[string]interface{}{"summary": op.Summary}
+	if !documented {
+		operation["summary"] = "Undocumented"
+	}
+
+	var params []interface{}
+	for _, name := range pathParams(path) {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true, "schema": schema("string"),
+		})
+	}
+	for _, p := range op.Query {
+		params = append(params, map[string]interface{}{
+			"name": p.Name, "in": "query", "description": p.Description, "schema": schema(p.Type),
+		})
+	}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+
+	if op.Body != "" {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{fiber.MIMEApplicationJSON: map[string]interface{}{"schema": ref(op.Body)}},
+		}
+	}
+	if op.Auth {
+		operation["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}}
+	}
+
+	result := op.Result
+	if result == "" {
+		result = "Envelope"
+	}
+	contentType := op.ContentType
+	if contentType == "" {
+		contentType = fiber.MIMEApplicationJSON
+	}
+	responses := map[string]interface{}{}
+	for status, description := range op.Responses {
+		name := "Error"
+		if status >= 300 && status < 400 {
+			responses[strconv.Itoa(status)] = map[string]interface{}{"description": description}
+			continue
+		} else if status < 300 {
+			name = result
+		}
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": description,
+			"content":     map[string]interface{}{contentType: map[string]interface{}{"schema": ref(name)}},
+		}
+	}
+	if op.Auth {
+		responses["401"] = map[string]interface{}{"description": "Missing or invalid api key"}
+		responses["403"] = map[string]interface{}{"description": "Authentication is disabled (AUTH_DISABLED)"}
+	}
+	if len(responses) == 0 {
+		responses["default"] = map[string]interface{}{"description": "Response envelope"}
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+// pathParams :: returns the names of the parameters in an openapi path template.
+func pathParams(path string) []string {
+	var params []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, segment[1:len(segment)-1])
+		}
+	}
+	return params
+}
+
+// BuildOpenAPISpec :: build the openapi 3 document from the routes registered on the app.
+// Only the versioned api is described, the unversioned /api/ prefix serves the exact same routes.
+func BuildOpenAPISpec(app *fiber.App) map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+
+	add := func(path, method string, operation map[string]interface{}) {
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(method)] = operation
+	}
+
+	for _, routes := range app.Stack() {
+		for _, route := range routes {
+			// The spec describes the paths relative to the server url, which carries the base path.
+			routePath := strings.TrimPrefix(route.Path, conf.BasePath)
+			// HEAD mirrors GET (the /{short} one is the existence check), no need to document it twice.
+			if route.Method == fiber.MethodHead {
+				continue
+			}
+			if strings.HasPrefix(routePath, openAPIPrefix) {
+				relative := openAPIPath(strings.TrimPrefix(routePath, openAPIPrefix))
+				if relative == "" {
+					relative = "/"
+				}
+				op, documented := apiOperations[route.Method+" "+relative]
+				add(openAPIPrefix+relative, route.Method, openAPIOperation(relative, op, documented))
+			} else {
+				path := openAPIPath(routePath)
+				if op, documented := publicOperations[route.Method+" "+path]; documented {
+					add(path, route.Method, openAPIOperation(path, op, documented))
+				}
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "TL;DR",
+			"description": "A small url shortener. Every route is also available under the unversioned /api/ prefix.",
+			"version":     "1",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": conf.BasePath + "/"}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": openAPISchemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+	}
+}
+
+// swaggerUI :: minimal page that renders the spec with swagger-ui (loaded from a cdn).
+const swaggerUI = `<!DOCTYPE html>
+<html>
+<head>
+	<title>TL;DR - API</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@3/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@3/swagger-ui-bundle.js"></script>
+	<script>SwaggerUIBundle({url: "%s/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// registerDocs :: serve the openapi spec at /openapi.json and swagger-ui at /docs (on the router, below the base path).
+// The spec is built once, after all other routes are registered.
+func registerDocs(app *fiber.App, router fiber.Router) {
+	spec := BuildOpenAPISpec(app)
+	if conf.JSONStyle == jsonStyleCamel {
+		spec = camelSchema(spec).(map[string]interface{})
+	}
+	page := fmt.Sprintf(swaggerUI, conf.BasePath)
+
+	router.Get("/openapi.json", func(c *fiber.Ctx) error {
+		return c.JSON(spec)
+	})
+	router.Get("/docs", func(c *fiber.Ctx) error {
+		c.Type("html")
+		return c.SendString(page)
+	})
+	if conf.Landing {
+		router.Get("/", landing)
+	}
+}
+
+// landing :: answer the root path with what this is and where the docs are, instead of a 404 that looks broken.
+func landing(c *fiber.Ctx) error {
+	type service struct {
+		Service string
+		Version string
+		Docs    string
+		OpenAPI string
+	}
+	base := conf.BaseURL
+	if base == "" {
+		base = c.BaseURL()
+	}
+	base += conf.BasePath
+	return SendPayload(c, 200, "Ok", service{
+		Service: "TL;DR url shortener",
+		Version: version,
+		Docs:    base + "/docs",
+		OpenAPI: base + "/openapi.json",
+	})
+}
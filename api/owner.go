@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// KeyOwner :: the owner of the urls created with the api key, a fingerprint so the key itself is never stored.
+func KeyOwner(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// requestOwner :: the owner of the urls the request creates, empty for requests without a valid api key.
+func requestOwner(c *fiber.Ctx) string {
+	key := requestApiKey(c)
+	if !IsValidApiKey(key) {
+		return ""
+	}
+	return KeyOwner(key)
+}
+
+// requestClient :: who sent the request, the owner of its api key or the client ip for requests without one.
+func requestClient(c *fiber.Ctx) string {
+	if owner := requestOwner(c); owner != "" {
+		return "owner:" + owner
+	}
+	return "ip:" + ClientIP(c)
+}
+
+// IsAdminKey :: returns true if the key may see the urls of every owner.
+// Without TLDR_ADMIN_KEYS every api key is an admin key, like before urls had owners.
+func IsAdminKey(key string) bool {
+	if len(conf.AdminKeys) == 0 {
+		return IsValidApiKey(key)
+	}
+	admin := false
+	for _, configured := range conf.AdminKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(configured)) == 1 {
+			admin = true
+		}
+	}
+	return admin
+}
+
+// requireAdmin :: middleware for routes that show the data of every owner, it runs after 'requireAuth'.
+func requireAdmin(c *fiber.Ctx) error {
+	if !IsAdminKey(requestApiKey(c)) {
+		return SendResponse(c, MakeErrorResponse(403, "ADMIN_REQUIRED", "This route needs an admin key"))
+	}
+	return c.Next()
+}
+
+// listOwner :: the owner a listing gets narrowed down to, empty lists the urls of everybody.
+// Admins and anonymous requests (only possible with TLDR_LIST_PUBLIC) see everything, other keys only their own urls.
+func listOwner(c *fiber.Ctx) string {
+	key := requestApiKey(c)
+	if !IsValidApiKey(key) || IsAdminKey(key) {
+		return ""
+	}
+	return KeyOwner(key)
+}
+
+// ownedUrl :: the url of the short if the key may change it, keys that aren't admin keys only reach their own urls.
+// Urls of other owners answer 404 like unknown shorts, so they can't be probed. Returns the response to send otherwise.
+func (h handler) ownedUrl(c *fiber.Ctx, short string) (Url, Data, bool) {
+	found, url, err := h.dbFor(c).GetUrlFromShort(short)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return url, MakeResponse(500, err.Error(), Url{}), false
+	}
+	if owner := listOwner(c); !found || (owner != "" && url.Owner != owner) {
+		msg := fmt.Sprintf("No URL found for short '%s'.", short)
+		return Url{}, MakeResponse(404, msg, Url{}), false
+	}
+	return url, Data{}, true
+}
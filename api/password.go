@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const linkPasswordHeader = "X-Link-Password"
+
+// HashPassword :: hash the link password with bcrypt, only the hash ever gets stored.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword :: returns true if the password matches the stored bcrypt hash.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// IsProtected :: returns true if the url requires a password to be resolved.
+func IsProtected(url Url) bool {
+	return url.PasswordHash != ""
+}
+
+// linkPassword :: returns the password sent to unlock a protected url, either as 'X-Link-Password' header or '?password='.
+func linkPassword(c *fiber.Ctx) string {
+	if password := c.Get(linkPasswordHeader); password != "" {
+		return password
+	}
+	return c.Query("password")
+}
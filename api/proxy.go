@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// validProxy :: returns true if the entry of TLDR_TRUSTED_PROXIES is an ip or a cidr range.
+func validProxy(proxy string) bool {
+	if strings.Contains(proxy, "/") {
+		_, _, err := net.ParseCIDR(proxy)
+		return err == nil
+	}
+	return net.ParseIP(proxy) != nil
+}
+
+// ClientIP :: the ip of the client, taken from the proxy header if the request came through a trusted proxy.
+// X-Forwarded-For is a list with the client first and every proxy appending the address it saw,
+// only the last entry was written by our (trusted) proxy, everything before it can be made up by the client.
+// Without the header (fiber returns an empty ip then) it is the address of the connection, i.e. the proxy.
+func ClientIP(c *fiber.Ctx) string {
+	ip := c.IP()
+	if i := strings.LastIndex(ip, ","); i >= 0 {
+		ip = ip[i+1:]
+	}
+	if ip = strings.TrimSpace(ip); ip != "" {
+		return ip
+	}
+	return c.Context().RemoteIP().String()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// purgeScopes :: the urls '?scope=' of 'Purge' selects, a used up url was disabled when its last use was counted.
+var purgeScopes = map[string]string{
+	"expired": `(expires_at <= ? OR valid = ?)`,
+	"invalid": `valid = ?`,
+	"both":    `(expires_at <= ? OR valid = ? OR valid = ?)`,
+}
+
+// purgeArgs :: the arguments of the purge scope condition.
+func purgeArgs(scope string) []interface{} {
+	now := time.Now().Unix()
+	switch scope {
+	case "expired":
+		return []interface{}{now, StatusExpired}
+	case "invalid":
+		return []interface{}{StatusDisabled}
+	}
+	return []interface{}{now, StatusExpired, StatusDisabled}
+}
+
+// PurgeUrls :: delete the urls in the scope (and their click history) in one transaction, only those of owner if it is set.
+func (d database) PurgeUrls(scope, owner string) (deletedUrls, error) {
+	condition, args := purgeScopes[scope], purgeArgs(scope)
+	if owner != "" {
+		condition += ` AND owner = ?`
+		args = append(args, owner)
+	}
+	return d.deleteWhere(condition, args)
+}
+
+// Purge :: delete all expired ('?scope=expired'), disabled and used up ('?scope=invalid') or both kinds ('?scope=both') of urls.
+// Keys that aren't admin keys only purge their own urls, flagged urls are never purged.
+func (h handler) Purge(c *fiber.Ctx) error {
+	// No default, deleting urls has to be asked for explicitly.
+	scope := c.Query("scope")
+	if _, ok := purgeScopes[scope]; !ok {
+		return SendResponse(c, MakeResponse(400, "scope must be expired, invalid or both", Url{}))
+	}
+
+	purged, err := h.dbFor(c).PurgeUrls(scope, listOwner(c))
+	h.links.Invalidate()
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	}
+	deleted := purged.Count()
+	log.Printf("INFO: Purged %d %s URLs (request %s)", deleted, scope, RequestID(c))
+	h.events.Deleted(purged)
+
+	type deleteResult struct {
+		Deleted int64
+	}
+	return SendPayload(c, 200, "Ok", deleteResult{Deleted: deleted})
+}
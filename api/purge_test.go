@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurgeUrls(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	seed := []Url{
+		{Short: "active", Valid: StatusActive},
+		{Short: "later", Valid: StatusActive, ExpiresAt: &future},
+		{Short: "disabled", Valid: StatusDisabled},
+		{Short: "owneddisabled", Valid: StatusDisabled, Owner: KeyOwner("admin")},
+		{Short: "expiredat", Valid: StatusActive, ExpiresAt: &past},
+		{Short: "expired", Valid: StatusExpired},
+		{Short: "flagged", Valid: StatusFlagged},
+	}
+	tests := []struct {
+		scope string
+		left  string
+	}{
+		{"invalid", "active expired expiredat flagged later"},
+		{"expired", "active disabled flagged later owneddisabled"},
+		{"both", "active flagged later"},
+	}
+	for _, test := range tests {
+		app, db := newTestApp(t, map[string]string{"TLDR_API_KEYS": "admin"})
+		for _, url := range seed {
+			// Seeded without an owner unless set, like anonymous urls (owner IS NULL).
+			url.Url = "https://example.com/" + url.Short
+			if err := db.InsertNewUrl(url); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		status, resp := send(t, app, "POST", "/api/purge?scope="+test.scope, "", "X-API-Key", "admin")
+		if want := float64(len(seed) - len(strings.Fields(test.left))); status != 200 || resp.Data["Deleted"] != want {
+			t.Errorf("scope %s: %d %s %v, want %v deleted", test.scope, status, resp.Message, resp.Data["Deleted"], want)
+		}
+		urls, err := db.GetAllUrls(UrlFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var left []string
+		for _, url := range urls {
+			left = append(left, url.Short)
+		}
+		sort.Strings(left)
+		if got := strings.Join(left, " "); got != test.left {
+			t.Errorf("scope %s left %q, want %q", test.scope, got, test.left)
+		}
+	}
+}
+
+func TestPurgeUrlsScope(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"TLDR_API_KEYS": "admin"})
+	for _, scope := range []string{"", "all", "valid"} {
+		if status, _ := send(t, app, "POST", "/api/purge?scope="+scope, "", "X-API-Key", "admin"); status != 400 {
+			t.Errorf("scope %q: %d, want 400", scope, status)
+		}
+	}
+}
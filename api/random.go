@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RandomUrl :: pick one random url that would resolve right now, found is false if there is none.
+// Password protected urls are left out, a random pick should never need a password. Only the urls of owner if it is set.
+func (d database) RandomUrl(owner string) (bool, Url, error) {
+	var url Url
+	query := `SELECT ` + urlColumns + ` FROM url WHERE valid = ? AND (expires_at IS NULL OR expires_at > ?)
+			  AND (active_from IS NULL OR active_from <= ?) AND (coalesce(max_uses, 0) = 0 OR uses < max_uses)
+			  AND coalesce(password_hash, '') = ''`
+	now := time.Now().Unix()
+	args := []interface{}{StatusActive, now, now}
+	if owner != "" {
+		query += ` AND owner = ?`
+		args = append(args, owner)
+	}
+	query += ` ORDER BY RANDOM() LIMIT 1`
+
+	err := d.checkDb()
+	if err != nil {
+		return false, url, err
+	}
+
+	row := d.db.QueryRowContext(d.context(), query, args...)
+	url, err = scanUrl(row)
+	switch err {
+	case sql.ErrNoRows:
+		return false, url, nil
+	case nil:
+		return true, url, nil
+	default:
+		return false, url, err
+	}
+}
+
+// RandomUrl :: returns a random url that currently resolves, 404 if there is none.
+// With '?redirect=true' it redirects to the short url instead, so the visit counts like any other click.
+func (h handler) RandomUrl(c *fiber.Ctx) error {
+	redirect := false
+	if c.Query("redirect") != "" {
+		var err error
+		redirect, err = strconv.ParseBool(c.Query("redirect"))
+		if err != nil {
+			return SendResponse(c, MakeResponse(400, "redirect must be true or false", Url{}))
+		}
+	}
+
+	found, url, err := h.dbFor(c).RandomUrl(listOwner(c))
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return SendResponse(c, MakeResponse(500, err.Error(), Url{}))
+	} else if !found {
+		return SendResponse(c, MakeResponse(404, "No URL available", Url{}))
+	}
+	url.ShortUrl = ShortUrl(c, url.Short)
+	if redirect {
+		// The pick changes on every request, caches must not keep it.
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.Redirect(url.ShortUrl, fiber.StatusFound)
+	}
+	url.hideClicks = !showClicks(c)
+	return SendResponse(c, MakeResponse(200, "Ok", url))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	reservationTTL = 5 * time.Minute
+	// maxReservations keeps everybody together from holding every alias they can think of,
+	// maxClientReservations a single client from using up all of them.
+	maxReservations       = 10000
+	maxClientReservations = 20
+)
+
+var (
+	// ErrReservationsFull :: 'maxReservations' aliases are held already, by everybody together.
+	ErrReservationsFull = errors.New("too many aliases are reserved")
+
+	// ErrReservationQuota :: the client holds 'maxClientReservations' aliases already.
+	ErrReservationQuota = errors.New("the client holds too many reservations")
+)
+
+// Reservation :: an alias held for whoever knows the token, until it expires.
+type Reservation struct {
+	Short     string
+	Token     string
+	ExpiresAt time.Time
+	// holder is the client that reserved the short, see 'requestClient'.
+	holder string
+}
+
+// reservationStore :: in-memory reservations by short, expired ones are dropped lazily.
+type reservationStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	max       int
+	maxClient int
+	entries   map[string]Reservation
+}
+
+// newReservationStore :: create a new store, reservations are held for the given ttl.
+// At most max shorts are held at once, at most maxClient of them by the same client.
+func newReservationStore(ttl time.Duration, max, maxClient int) *reservationStore {
+	return &reservationStore{ttl: ttl, max: max, maxClient: maxClient, entries: make(map[string]Reservation)}
+}
+
+// Reserve :: hold the short for the client, renews the reservation if token is the one it is held with.
+// Returns false if somebody else holds it, 'ErrReservationQuota' if the client holds too many shorts already
+// and 'ErrReservationsFull' if the store is full.
+func (s *reservationStore) Reserve(short, token, client string) (Reservation, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.dropExpired(now)
+	if held, ok := s.entries[short]; ok && (token == "" || held.Token != token) {
+		return held, false, nil
+	} else if !ok {
+		if len(s.entries) >= s.max {
+			return Reservation{}, false, ErrReservationsFull
+		} else if s.heldBy(client) >= s.maxClient {
+			return Reservation{}, false, ErrReservationQuota
+		}
+		var err error
+		if token, err = reservationToken(); err != nil {
+			return Reservation{}, false, err
+		}
+	}
+
+	reservation := Reservation{Short: short, Token: token, ExpiresAt: now.Add(s.ttl), holder: client}
+	s.entries[short] = reservation
+	return reservation, true, nil
+}
+
+// Blocks :: true if the short is held by somebody else than the holder of token.
+func (s *reservationStore) Blocks(short, token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	held, ok := s.entries[short]
+	if !ok || time.Now().After(held.ExpiresAt) {
+		return false
+	}
+	return held.Token != token
+}
+
+// Release :: free the short, e.g. once it got created.
+func (s *reservationStore) Release(short string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, short)
+}
+
+// dropExpired :: forget all reservations that expired, the caller holds the lock.
+func (s *reservationStore) dropExpired(now time.Time) {
+	for short, held := range s.entries {
+		if now.After(held.ExpiresAt) {
+			delete(s.entries, short)
+		}
+	}
+}
+
+// heldBy :: the number of shorts the client holds, the caller holds the lock.
+func (s *reservationStore) heldBy(client string) int {
+	held := 0
+	for _, reservation := range s.entries {
+		if reservation.holder == client {
+			held++
+		}
+	}
+	return held
+}
+
+// reservationToken :: a random token, it is all that's needed to claim a reserved alias.
+func reservationToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// ReserveAlias :: hold an available alias for a few minutes, e.g. while the user fills in the rest of a form.
+// The returned 'Token' claims it as 'reservation' on create, until then nobody else can create or reserve it.
+// Sending the token again renews the reservation. Every client (api key owner, or ip without a key)
+// holds at most 20 aliases at once.
+// Post body example:
+//
+//	{
+//		"alias": "example",
+//		"namespace": "team1",
+//		"reservation": "<token of an earlier reservation>"
+//	}
+func (h handler) ReserveAlias(c *fiber.Ctx) error {
+	type reservePost struct {
+		Alias       string `json:"alias"`
+		Namespace   string `json:"namespace"`
+		Reservation string `json:"reservation"`
+	}
+	post := new(reservePost)
+
+	if data, ok := parseBody(c, post, "alias"); !ok {
+		return SendResponse(c, data)
+	}
+	namespace, data, ok := checkNamespace(post.Namespace)
+	if !ok {
+		return SendResponse(c, data)
+	}
+	short, data, ok := h.checkAlias(c, post.Alias, namespace, post.Reservation)
+	if !ok {
+		return SendResponse(c, data)
+	}
+
+	reservation, ok, err := reservations.Reserve(short, post.Reservation, requestClient(c))
+	if err == ErrReservationQuota {
+		msg := fmt.Sprintf("At most %d aliases can be reserved at once, claim or wait for some of them.", maxClientReservations)
+		return SendResponse(c, MakeErrorResponse(429, "TOO_MANY_RESERVATIONS", msg))
+	} else if err != nil {
+		log.Printf("WARN: Could not reserve '%s': %s", short, err.Error())
+		return SendResponse(c, MakeErrorResponse(503, "RESERVATIONS_FULL", "Too many aliases are reserved, try again later"))
+	} else if !ok {
+		return SendResponse(c, aliasHeldResponse(short))
+	}
+	return SendPayload(c, 201, "Reserved", reservation)
+}
+
+// aliasHeldResponse :: the response for an alias that somebody else reserved.
+func aliasHeldResponse(short string) Data {
+	msg := fmt.Sprintf("Alias '%s' is reserved by somebody else, try again in a few minutes.", short)
+	return MakeErrorResponse(409, "ALIAS_HELD", msg)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	retryAttempts = 5
+	retryBackoff  = 10 * time.Millisecond
+	// retryAfterDefault :: seconds a client is told to wait ('Retry-After') before retrying a 429 or 503.
+	retryAfterDefault = 5
+)
+
+// setRetryAfter :: add a 'Retry-After' header to 429 and 503 responses, unless the handler already set a better estimate.
+func setRetryAfter(c *fiber.Ctx, status int) {
+	if status != fiber.StatusTooManyRequests && status != fiber.StatusServiceUnavailable {
+		return
+	}
+	if c.GetRespHeader(fiber.HeaderRetryAfter) == "" {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfterDefault))
+	}
+}
+
+// waitForDatabase :: ping the database until it answers, sql.Open alone doesn't connect.
+// A database that isn't there yet (e.g. a volume that gets mounted late) gets a few attempts with exponential backoff.
+func (d database) waitForDatabase(attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = d.db.PingContext(d.context()); err == nil {
+			return nil
+		}
+		if attempt < attempts {
+			log.Printf("WARN: Database is unreachable (attempt %d/%d), retrying in %s: %s", attempt, attempts, backoff, err.Error())
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// isRetryable :: returns true if the error is a transient sqlite 'database is locked/busy' error.
+func isRetryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// withRetry :: run the (write) operation, retrying with exponential backoff while it fails with a transient error.
+// The error is only returned once all attempts are used up (or if it isn't retryable).
+func withRetry(op func() error) error {
+	var err error
+	backoff := retryBackoff
+
+	for attempt := 1; attempt <= retryAttempts; attempt++ {
+		err = op()
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt < retryAttempts {
+			log.Printf("WARN: Database is busy (attempt %d/%d), retrying in %s", attempt, retryAttempts, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
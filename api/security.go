@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// tlsVersions :: the values TLDR_TLS_MIN_VERSION accepts.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// listenTLS :: serve https on addr, clients have to speak at least TLDR_TLS_MIN_VERSION.
+// 'app.ListenTLS' doesn't take a tls config, so the listener is set up here.
+func listenTLS(app *fiber.App, addr string) error {
+	cert, err := tls.LoadX509KeyPair(conf.TLSCert, conf.TLSKey)
+	if err != nil {
+		return fmt.Errorf("could not load TLDR_TLS_CERT and TLDR_TLS_KEY: %s", err.Error())
+	}
+	ln, err := tls.Listen("tcp", addr, &tls.Config{
+		MinVersion:   tlsVersions[conf.TLSMinVersion],
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		return err
+	}
+	return app.Listener(ln)
+}
+
+// securityHeaders :: middleware that sets the usual hardening headers on every response (TLDR_SECURITY_HEADERS).
+// HSTS is only sent over https, that includes https terminated by a trusted proxy (X-Forwarded-Proto),
+// which 'c.Secure()' doesn't look at.
+func securityHeaders(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	if conf.ReferrerPolicy != "" {
+		c.Set(fiber.HeaderReferrerPolicy, conf.ReferrerPolicy)
+	}
+	if conf.HSTSMaxAge > 0 && c.Protocol() == "https" {
+		c.Set(fiber.HeaderStrictTransportSecurity, "max-age="+strconv.Itoa(conf.HSTSMaxAge))
+	}
+	return c.Next()
+}
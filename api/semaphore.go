@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// limitedTransport :: round tripper that lets at most cap(slots) requests run at the same time,
+// the others wait for a free slot (or until their context is done).
+// A slot is held until the response body is closed, reading a big body counts as part of the request.
+type limitedTransport struct {
+	next  http.RoundTripper
+	slots chan struct{}
+}
+
+// newLimitedTransport :: limit next to n concurrent requests.
+func newLimitedTransport(next http.RoundTripper, n int) *limitedTransport {
+	return &limitedTransport{next: next, slots: make(chan struct{}, n)}
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		<-t.slots
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { <-t.slots }}
+	return resp, nil
+}
+
+// releasingBody :: response body that frees the slot of its request on the first Close.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// limitEnrichment :: cap the outbound requests of the enrich client (favicon, target checks, reputation) to n at a time,
+// so a burst of new urls can't open an unbounded number of connections.
+func limitEnrichment(n int) {
+	enrichClient.Transport = newLimitedTransport(publicTransport, n)
+}
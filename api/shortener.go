@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	uri "net/url"
+)
+
+const (
+	shortenerModeOff    = "off"
+	shortenerModeReject = "reject"
+	shortenerModeUnwrap = "unwrap"
+
+	// defaultShortenerHosts :: well-known url shorteners, subdomains match as well.
+	defaultShortenerHosts = "bit.ly,t.co,tinyurl.com,goo.gl,ow.ly,is.gd,buff.ly,rebrand.ly,cutt.ly,tiny.cc,shorturl.at"
+)
+
+// isShortenedUrl :: true if the target is a link of a known shortener (TLDR_SHORTENER_HOSTS) or of this instance.
+func isShortenedUrl(c *fiber.Ctx, target string) bool {
+	if strings.HasPrefix(strings.ToLower(target), strings.ToLower(shortUrlBase(c))) {
+		return true
+	}
+	parsed, err := uri.Parse(target)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, shortener := range conf.ShortenerHosts {
+		shortener = strings.ToLower(shortener)
+		if host == shortener || strings.HasSuffix(host, "."+shortener) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkShortener :: handle targets that are short links themselves according to TLDR_SHORTENER_MODE,
+// shortening them again only adds another hop. Returns the target to store or the response to send.
+func checkShortener(c *fiber.Ctx, target string) (string, Data, bool) {
+	if conf.ShortenerMode == shortenerModeOff || !isShortenedUrl(c, target) {
+		return target, Data{}, true
+	}
+	if conf.ShortenerMode == shortenerModeReject {
+		msg := "URL is a short link already, shorten the url it points to instead"
+		return "", MakeErrorResponse(422, "ALREADY_SHORTENED", msg), false
+	}
+
+	// Only a single hop is followed, a short link to another short link gets rejected below.
+	unwrapped, err := unwrapShortUrl(c.UserContext(), target)
+	if err != nil {
+		log.Printf("WARN: Could not unwrap the short link '%s': %s", target, err.Error())
+		msg := fmt.Sprintf("URL is a short link that could not be unwrapped: %s", err.Error())
+		return "", MakeErrorResponse(422, "ALREADY_SHORTENED", msg), false
+	}
+	unwrapped, data, ok := normalizeTarget(unwrapped)
+	if !ok {
+		return "", data, false
+	}
+	if isShortenedUrl(c, unwrapped) {
+		return "", MakeErrorResponse(422, "ALREADY_SHORTENED", "URL is a short link to another short link"), false
+	}
+	log.Printf("INFO: Unwrapped the short link '%s' to '%s'", target, unwrapped)
+	return unwrapped, Data{}, true
+}
+
+// unwrapShortUrl :: the url the short link redirects to, without following that redirect.
+func unwrapShortUrl(ctx context.Context, target string) (string, error) {
+	// A copy of the enrich client, it shares the limited transport but stops at the first redirect.
+	client := *enrichClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := sendWith(ctx, &client, http.MethodHead, target)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = sendWith(ctx, &client, http.MethodGet, target)
+	}
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" {
+		return "", fmt.Errorf("it answered with status %d instead of a redirect", resp.StatusCode)
+	}
+	// The location may be relative to the short link.
+	ref, err := uri.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return resp.Request.URL.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+const (
+	// signatureSeparator :: separates the short from its signature, '.' is never part of a short.
+	signatureSeparator = "."
+	// signatureBytes :: the HMAC is truncated to this many bytes, enough to make guessing hopeless and keep the url short.
+	signatureBytes = 8
+)
+
+// shortSignature :: the HMAC-SHA256 of the short with TLDR_SIGNING_SECRET, url-safe base64 encoded.
+func shortSignature(short string) string {
+	mac := hmac.New(sha256.New, []byte(conf.SigningSecret))
+	mac.Write([]byte(short))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureBytes])
+}
+
+// SignShort :: append the signature to the short ('abc.<sig>'), the short stays as is when signing is off.
+func SignShort(short string) string {
+	if conf.SigningSecret == "" {
+		return short
+	}
+	return short + signatureSeparator + shortSignature(short)
+}
+
+// VerifyShort :: check the signature of a short that came from 'SignShort' and strip it, so anyone holding
+// the secret can tell that the server issued the short without looking it up.
+// With signing off every short passes unchanged, with signing on unsigned and tampered shorts fail.
+func VerifyShort(signed string) (string, bool) {
+	if conf.SigningSecret == "" {
+		return signed, true
+	}
+	i := strings.LastIndex(signed, signatureSeparator)
+	if i < 0 {
+		return "", false
+	}
+	short, signature := signed[:i], signed[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(shortSignature(short))) {
+		return "", false
+	}
+	return short, true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSignedLookups(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		// short is called once the config of the secret is loaded.
+		short func() string
+		found bool
+	}{
+		{"signed", "secret", func() string { return SignShort("abc") }, true},
+		{"unsigned", "secret", func() string { return "abc" }, false},
+		{"tampered", "secret", func() string { return "abc" + signatureSeparator + "AAAAAAAAAAA" }, false},
+		// A valid signature, just not the one of this short.
+		{"other short", "secret", func() string { return "abd" + signatureSeparator + shortSignature("abc") }, false},
+		{"signing off", "", func() string { return "abc" }, true},
+	}
+	for _, test := range tests {
+		app, db := newTestApp(t, map[string]string{"TLDR_SIGNING_SECRET": test.secret})
+		for _, short := range []string{"abc", "abd"} {
+			if err := db.InsertNewUrl(Url{Short: short, Url: "https://example.com/" + short, Valid: StatusActive}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		short := test.short()
+
+		want := 404
+		if test.found {
+			want = 204
+		}
+		for _, method := range []string{"GET", "HEAD"} {
+			path := "/api/" + short
+			if method == "GET" {
+				path += "/exists"
+			}
+			if resp, _ := sendRaw(t, app, method, path, ""); resp.StatusCode != want {
+				t.Errorf("%s: %s %s is %d, want %d", test.name, method, path, resp.StatusCode, want)
+			}
+		}
+
+		body, _ := json.Marshal(map[string][]string{"shorts": {short}})
+		status, resp := send(t, app, "POST", "/api/resolve", string(body))
+		if status != 200 {
+			t.Fatalf("%s: resolve is %d %s", test.name, status, resp.Message)
+		}
+		res, _ := resp.Data[short].(map[string]interface{})
+		if res["Found"] != test.found {
+			t.Errorf("%s: resolve found %v, want %v", test.name, res["Found"], test.found)
+		} else if test.found && res["Url"] != "https://example.com/abc" {
+			t.Errorf("%s: resolved to %v", test.name, res["Url"])
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// The states of a url, stored in the 'valid' column. Only active urls redirect.
+// 0 and 1 keep their old meaning (invalid/valid), so existing databases don't need to be rewritten.
+const (
+	StatusDisabled = 0
+	StatusActive   = 1
+	StatusExpired  = 2
+	StatusFlagged  = 3
+)
+
+var statusNames = map[int]string{
+	StatusDisabled: "disabled",
+	StatusActive:   "active",
+	StatusExpired:  "expired",
+	StatusFlagged:  "flagged",
+}
+
+// StatusName :: the name of the status, unknown values count as disabled.
+func StatusName(status int) string {
+	if name, ok := statusNames[status]; ok {
+		return name
+	}
+	return statusNames[StatusDisabled]
+}
+
+// ParseStatus :: the reverse of 'StatusName', fails for unknown names.
+func ParseStatus(name string) (int, error) {
+	for status, n := range statusNames {
+		if n == name {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("status must be one of active, disabled, expired or flagged")
+}
+
+// UpdateStatus :: change the status of the short, returns false if the short doesn't exist.
+func (d database) UpdateStatus(short string, status int) (bool, error) {
+	query := `UPDATE url SET valid = ? WHERE ` + shortEquals()
+
+	err := d.checkDb()
+	if err != nil {
+		return false, err
+	}
+
+	var affected int64
+	err = withRetry(func() error {
+		res, err := d.db.ExecContext(d.context(), query, status, short)
+		if err != nil {
+			return err
+		}
+		affected, err = res.RowsAffected()
+		return err
+	})
+	cachedUrls.Remove(short)
+	return affected == 1, err
+}
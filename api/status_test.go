@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRedirectStatus(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name   string
+		url    Url
+		status int
+		code   string
+	}{
+		{"active", Url{Valid: StatusActive}, 302, ""},
+		{"disabled", Url{Valid: StatusDisabled}, 422, ""},
+		{"expired", Url{Valid: StatusExpired}, 410, "EXPIRED"},
+		{"expires_at passed", Url{Valid: StatusActive, ExpiresAt: &past}, 410, "EXPIRED"},
+		{"flagged", Url{Valid: StatusFlagged}, 403, "FLAGGED"},
+		{"used up", Url{Valid: StatusActive, MaxUses: 1, Uses: 1}, 410, ""},
+	}
+	app, db := newTestApp(t, nil)
+	for i, test := range tests {
+		test.url.Short = fmt.Sprintf("s%d", i)
+		test.url.Url = "https://example.com/" + test.url.Short
+		if err := db.InsertNewUrl(test.url); err != nil {
+			t.Fatal(err)
+		}
+		// Uses only ever go up through redirects, the insert leaves them at 0.
+		if _, err := db.db.Exec(`UPDATE url SET uses = ? WHERE short = ?`, test.url.Uses, test.url.Short); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, _ := sendRaw(t, app, "GET", "/s/"+test.url.Short, "")
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: redirect is %d, want %d", test.name, resp.StatusCode, test.status)
+		} else if test.status == 302 && resp.Header.Get("Location") != test.url.Url {
+			t.Errorf("%s: redirects to %q", test.name, resp.Header.Get("Location"))
+		}
+		if status, data := send(t, app, "GET", "/api/"+test.url.Short, ""); test.status != 302 && (status != test.status || data.Code != test.code) {
+			t.Errorf("%s: lookup is %d %s, want %d %s", test.name, status, data.Code, test.status, test.code)
+		}
+	}
+	if status, _ := send(t, app, "GET", "/s/unknown", ""); status != 404 {
+		t.Errorf("unknown short: %d, want 404", status)
+	}
+}
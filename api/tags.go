@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	maxTags      = 10
+	maxTagLength = 32
+	tagCharset   = "abcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// NormalizeTags :: lowercase, trim and deduplicate the tags, returns an error for invalid tags.
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxTagLength || strings.Trim(tag, tagCharset) != "" {
+			return nil, fmt.Errorf("tag '%s' is invalid, tags may only contain letters, digits, '-' and '_' and be at most %d characters long", tag, maxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("a URL can have at most %d tags", maxTags)
+	}
+	return normalized, nil
+}
+
+// joinTags :: store the tags as ',a,b,', the surrounding commas allow matching a single tag by searching for ',tag,'.
+func joinTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "," + strings.Join(tags, ",") + ","
+}
+
+// splitTags :: the reverse of 'joinTags'.
+func splitTags(tags string) []string {
+	tags = strings.Trim(tags, ",")
+	if tags == "" {
+		return []string{}
+	}
+	return strings.Split(tags, ",")
+}
+
+// UpdateTags :: replace the tags of the short, returns false if the short doesn't exist.
+func (d database) UpdateTags(short string, tags []string) (bool, error) {
+	query := `UPDATE url SET tags = ? WHERE ` + shortEquals()
+
+	err := d.checkDb()
+	if err != nil {
+		return false, err
+	}
+
+	var affected int64
+	err = withRetry(func() error {
+		res, err := d.db.ExecContext(d.context(), query, joinTags(tags), short)
+		if err != nil {
+			return err
+		}
+		affected, err = res.RowsAffected()
+		return err
+	})
+	cachedUrls.Remove(short)
+	return affected == 1, err
+}
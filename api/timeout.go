@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestTimeout :: middleware that gives every request a deadline of TLDR_REQUEST_TIMEOUT seconds.
+// The deadline is the user context of the request, queries and outbound calls started with it get cancelled
+// once it passes. That is not a hard cap, handlers aren't interrupted from the outside (fasthttp reuses the context
+// as soon as the request is done), a handler that is busy with something else finishes first.
+// Only a request that failed after the deadline is answered with 503, a handler that got its work done
+// (e.g. committed an insert or delete) in time keeps its response even if it was late.
+func requestTimeout(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if timeout <= 0 {
+			return c.Next()
+		}
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		if ctx.Err() != context.DeadlineExceeded {
+			return err
+		}
+		log.Printf("WARN: Request %s %s exceeded the timeout of %s", c.Method(), c.OriginalURL(), timeout)
+		if err == nil && c.Response().StatusCode() < fiber.StatusInternalServerError {
+			return nil
+		}
+		c.Response().Header.Del(fiber.HeaderLocation)
+		data := MakeErrorResponse(503, "TIMEOUT", "The request took too long, try again later")
+		return SendResponse(c, data)
+	}
+}
+
+// WithContext :: a copy of the database whose queries are bound to ctx.
+func (d database) WithContext(ctx context.Context) database {
+	d.ctx = ctx
+	return d
+}
+
+// context :: the context queries run with, see 'WithContext'.
+func (d database) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+// dbFor :: the database bound to the deadline of the request.
+func (h handler) dbFor(c *fiber.Ctx) database {
+	return h.db.WithContext(c.UserContext())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+	app.Use(requestTimeout(20 * time.Millisecond))
+	app.Get("/fast", func(c *fiber.Ctx) error {
+		return SendPayload(c, 200, "Ok", nil)
+	})
+	// Late, but the work is done: e.g. a committed insert, its response has to reach the client.
+	app.Get("/late", func(c *fiber.Ctx) error {
+		time.Sleep(50 * time.Millisecond)
+		return SendPayload(c, 201, "Created", nil)
+	})
+	// A query that got cancelled by the deadline.
+	app.Get("/cancelled", func(c *fiber.Ctx) error {
+		<-c.UserContext().Done()
+		return SendPayload(c, 500, c.UserContext().Err().Error(), nil)
+	})
+	app.Get("/error", func(c *fiber.Ctx) error {
+		time.Sleep(50 * time.Millisecond)
+		return fiber.ErrBadGateway
+	})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/fast", 200},
+		{"/late", 201},
+		{"/cancelled", 503},
+		{"/error", 503},
+	}
+	for _, test := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", test.path, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("%s answered %d, want %d", test.path, resp.StatusCode, test.status)
+		}
+	}
+}
+
+func TestRequestTimeoutCancelsQueries(t *testing.T) {
+	_, db := newTestApp(t, nil)
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+	app.Use(requestTimeout(time.Nanosecond))
+	app.Get("/", func(c *fiber.Ctx) error {
+		<-c.UserContext().Done()
+		h := handler{db: db}
+		if _, _, err := h.dbFor(c).GetUrlFromShort("any"); err == nil {
+			t.Error("the query ran past the deadline")
+		}
+		return SendPayload(c, 500, "Query failed", nil)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 503 {
+		t.Errorf("answered %d, want 503", resp.StatusCode)
+	}
+}
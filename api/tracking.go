@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+
+	uri "net/url"
+)
+
+// trackingParams :: query parameters that only exist for tracking, 'utm_' matches every parameter with that prefix.
+var trackingParams = []string{"utm_", "fbclid", "gclid"}
+
+// isTrackingParam :: returns true if the query parameter is one of the 'trackingParams'.
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, param := range trackingParams {
+		if strings.HasSuffix(param, "_") && strings.HasPrefix(name, param) || name == param {
+			return true
+		}
+	}
+	return false
+}
+
+// StripTrackingParams :: remove the tracking parameters from the query of the url.
+// The remaining parameters keep their order and encoding, 'url.Values' would sort and re-encode them.
+func StripTrackingParams(u *uri.URL) {
+	if u.RawQuery == "" {
+		return
+	}
+	var kept []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		name := strings.SplitN(pair, "=", 2)[0]
+		if unescaped, err := uri.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !isTrackingParam(name) {
+			kept = append(kept, pair)
+		}
+	}
+	u.RawQuery = strings.Join(kept, "&")
+}
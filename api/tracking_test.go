@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	uri "net/url"
+)
+
+func TestStripTrackingParams(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/?utm_source=x&id=5", "https://example.com/?id=5"},
+		{"https://example.com/?id=5&UTM_Medium=mail&fbclid=1&gclid=2", "https://example.com/?id=5"},
+		// Nothing but tracking, the '?' goes too.
+		{"https://example.com/a?utm_source=x&utm_campaign=y", "https://example.com/a"},
+		{"https://example.com/a?utm_source=x#top", "https://example.com/a#top"},
+		{"https://example.com/a?b=%2F&utm_source=x&a=1#top", "https://example.com/a?b=%2F&a=1#top"},
+		// Only 'utm_' is a prefix, 'fbclid' has to match as a whole.
+		{"https://example.com/?fbclid_x=1&utm=2", "https://example.com/?fbclid_x=1&utm=2"},
+		{"https://example.com/#utm_source=x", "https://example.com/#utm_source=x"},
+	}
+	for _, test := range tests {
+		u, err := uri.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		StripTrackingParams(u)
+		if got := u.String(); got != test.want {
+			t.Errorf("StripTrackingParams(%s) = %s, want %s", test.url, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// urlCacheTTL :: how long a cached url is trusted, changes that don't go through the api (e.g. by hand) show up after it.
+const urlCacheTTL = time.Minute
+
+type urlCacheEntry struct {
+	url     Url
+	expires time.Time
+}
+
+// urlCache :: in-memory LRU of the urls that get resolved, so hot shorts don't hit the database on every redirect.
+// Every change of a url through 'database' removes it from the cache, a nil cache (TLDR_CACHE_SIZE=0) caches nothing.
+// The cached url still goes through all checks (expiry, max uses, ...) before it is served.
+type urlCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// newUrlCache :: create a cache of at most size urls, nil if size is 0.
+func newUrlCache(size int) *urlCache {
+	if size == 0 {
+		return nil
+	}
+	return &urlCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get :: the cached url of the short, false if it isn't cached (anymore).
+func (u *urlCache) Get(short string) (Url, bool) {
+	if u == nil {
+		return Url{}, false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	elem, ok := u.entries[canonicalShort(short)]
+	if !ok {
+		return Url{}, false
+	}
+	entry := elem.Value.(*urlCacheEntry)
+	if time.Now().After(entry.expires) {
+		u.remove(elem)
+		return Url{}, false
+	}
+	u.order.MoveToFront(elem)
+	return entry.url, true
+}
+
+// Set :: cache the url, the least recently used one gets evicted when the cache is full.
+func (u *urlCache) Set(url Url) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	key := canonicalShort(url.Short)
+	if elem, ok := u.entries[key]; ok {
+		u.remove(elem)
+	}
+	u.entries[key] = u.order.PushFront(&urlCacheEntry{url: url, expires: time.Now().Add(urlCacheTTL)})
+	for u.order.Len() > u.size {
+		u.remove(u.order.Back())
+	}
+}
+
+// AddUse :: count a use of the cached url the way 'UseUrl' does in the database, uncached shorts are ignored.
+func (u *urlCache) AddUse(short string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if elem, ok := u.entries[canonicalShort(short)]; ok {
+		entry := elem.Value.(*urlCacheEntry)
+		entry.url.Uses++
+		if entry.url.MaxUses > 0 && entry.url.Uses >= entry.url.MaxUses {
+			entry.url.Valid = StatusDisabled
+			entry.url.Status = StatusName(StatusDisabled)
+		}
+	}
+}
+
+// Remove :: forget the cached urls of the shorts, e.g. because they changed.
+func (u *urlCache) Remove(shorts ...string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, short := range shorts {
+		if elem, ok := u.entries[canonicalShort(short)]; ok {
+			u.remove(elem)
+		}
+	}
+}
+
+// Clear :: forget every cached url.
+func (u *urlCache) Clear() {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.order.Init()
+	u.entries = make(map[string]*list.Element)
+}
+
+// remove :: drop the entry, the caller holds the lock.
+func (u *urlCache) remove(elem *list.Element) {
+	u.order.Remove(elem)
+	delete(u.entries, canonicalShort(elem.Value.(*urlCacheEntry).url.Short))
+}
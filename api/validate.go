@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FieldError :: what is wrong with one field of a request body.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// parseBody :: strictly decode the json body into v, returns the response to send if it doesn't fit.
+// Unlike 'BodyParser' unknown fields and values of the wrong type are rejected (422 with the 'FieldErrors'),
+// so are missing or null required fields. Broken json stays a 400, an empty body counts as '{}'.
+func parseBody(c *fiber.Ctx, v interface{}, required ...string) (Data, bool) {
+	body := c.Body()
+	if len(bytes.TrimSpace(body)) == 0 {
+		body = []byte("{}")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return MakeResponse(400, err.Error(), Url{}), false
+		case errors.As(err, &typeErr):
+			return invalidBody(FieldError{Field: typeErr.Field, Message: "must be " + jsonType(typeErr.Type)}), false
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+			return invalidBody(FieldError{Field: field, Message: "is not a known field"}), false
+		default:
+			// e.g. a time that isn't RFC 3339, the decoder doesn't tell which field it was.
+			return invalidBody(FieldError{Message: err.Error()}), false
+		}
+	}
+
+	// A second, loose pass to tell missing fields apart from zero values.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return MakeResponse(400, err.Error(), Url{}), false
+	}
+	var missing []FieldError
+	for _, field := range required {
+		if value, ok := fields[field]; !ok || string(value) == "null" {
+			missing = append(missing, FieldError{Field: field, Message: "is required"})
+		}
+	}
+	if len(missing) > 0 {
+		return invalidBody(missing...), false
+	}
+	return Data{}, true
+}
+
+// invalidBody :: the 422 response for a body with the field errors.
+func invalidBody(errs ...FieldError) Data {
+	var parts []string
+	for _, e := range errs {
+		parts = append(parts, strings.TrimSpace(e.Field+" "+e.Message))
+	}
+	data := MakeErrorResponse(422, "INVALID_BODY", fmt.Sprintf("Invalid body: %s", strings.Join(parts, ", ")))
+	data.FieldErrors = errs
+	return data
+}
+
+// jsonType :: the json name of the type a value should have had.
+func jsonType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(jsonType(t.Elem()), "a "), "an ") + "s"
+	case reflect.Struct:
+		if t.String() == "time.Time" {
+			return "an RFC 3339 time string"
+		}
+	}
+	return "an object"
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookSignatureHeader = "X-TLDR-Signature"
+	webhookTimeout         = 5 * time.Second
+)
+
+// webhook :: notifies an external service (TLDR_WEBHOOK_URL) about new shorts.
+// A nil webhook is valid and does nothing, that's what you get when no url is configured.
+type webhook struct {
+	url    string
+	secret string
+	client *http.Client
+	// attempts and backoff are TLDR_WEBHOOK_ATTEMPTS and TLDR_WEBHOOK_BACKOFF_MS, see 'deliver'.
+	attempts int
+	backoff  time.Duration
+	// db keeps the dead letters, see 'deadLetter'.
+	db database
+}
+
+// webhookEvent :: the json body posted to the webhook.
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	Url       string    `json:"url"`
+	Short     string    `json:"short"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newWebhook :: create the webhook for the configuration, nil if TLDR_WEBHOOK_URL is unset.
+func newWebhook(c config, db database) *webhook {
+	if c.WebhookURL == "" {
+		return nil
+	}
+	return &webhook{
+		url:      c.WebhookURL,
+		secret:   c.WebhookSecret,
+		client:   &http.Client{Timeout: webhookTimeout},
+		attempts: c.WebhookAttempts,
+		backoff:  c.WebhookBackoff,
+		db:       db,
+	}
+}
+
+// Created :: notify about a newly created short in the background, failures are only logged.
+func (w *webhook) Created(url Url) {
+	if w == nil {
+		return
+	}
+	event := webhookEvent{Event: "created", Url: url.Url, Short: url.Short, CreatedAt: time.Now().UTC()}
+	if url.CreatedAt != nil {
+		event.CreatedAt = *url.CreatedAt
+	}
+	go w.send(event)
+}
+
+// send :: post the event, a delivery that fails every attempt ends up as dead letter.
+func (w *webhook) send(event webhookEvent) {
+	about := "'" + event.Short + "'"
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		return
+	}
+	if err = w.retry(about, body); err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		w.deadLetter(about, body, err)
+	}
+}
+
+// deliver :: post the event with retries, see 'retry'.
+func (w *webhook) deliver(about string, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return w.retry(about, body)
+}
+
+// retry :: post the body, retries with a doubling delay if the receiver fails or can't be reached.
+// about names the event in the logs, the error is the one of the last attempt.
+func (w *webhook) retry(about string, body []byte) error {
+	for attempt := 1; ; attempt++ {
+		err := w.post(body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= w.attempts {
+			return fmt.Errorf("webhook for %s failed after %d attempts: %s", about, attempt, err.Error())
+		}
+		log.Printf("WARN: Webhook for %s failed (attempt %d/%d), retrying: %s", about, attempt, w.attempts, err.Error())
+		time.Sleep(w.backoff << (attempt - 1))
+	}
+}
+
+// post :: a single delivery, any non 2xx answer counts as failure.
+func (w *webhook) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhook :: hex encoded HMAC-SHA256 of the body, receivers recompute it with the shared secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// flakyReceiver :: a webhook receiver that fails the first 'failures' posts, then takes them.
+type flakyReceiver struct {
+	failures int32
+	posts    int32
+}
+
+func (r *flakyReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if atomic.AddInt32(&r.posts, 1) <= atomic.LoadInt32(&r.failures) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newFlakyWebhook :: a receiver failing that many posts and the app and webhook posting to it, without backoff.
+func newFlakyWebhook(t *testing.T, failures int32) (*flakyReceiver, *webhook, database) {
+	receiver := &flakyReceiver{failures: failures}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	_, db := newTestApp(t, map[string]string{
+		"TLDR_WEBHOOK_URL":        server.URL,
+		"TLDR_WEBHOOK_ATTEMPTS":   "3",
+		"TLDR_WEBHOOK_BACKOFF_MS": "0",
+	})
+	return receiver, newWebhook(conf, db), db
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		failures    int32
+		posts       int32
+		deadLetters int
+	}{
+		{0, 1, 0},
+		{2, 3, 0},
+		// Every attempt fails, the delivery is kept once.
+		{3, 3, 1},
+		{10, 3, 1},
+	}
+	for _, test := range tests {
+		receiver, w, db := newFlakyWebhook(t, test.failures)
+		w.send(webhookEvent{Event: "created", Url: "https://example.com", Short: "abc"})
+
+		if posts := atomic.LoadInt32(&receiver.posts); posts != test.posts {
+			t.Errorf("%d failures: %d posts, want %d", test.failures, posts, test.posts)
+		}
+		letters, err := db.DeadLetters(deadLetterListLimit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(letters) != test.deadLetters {
+			t.Errorf("%d failures: %d dead letters, want %d", test.failures, len(letters), test.deadLetters)
+		} else if len(letters) == 1 && (letters[0].Attempts != 3 || letters[0].About != "'abc'") {
+			t.Errorf("%d failures: dead letter %+v, want 3 attempts for 'abc'", test.failures, letters[0])
+		}
+	}
+}
+
+func TestDeadLetterEndpoints(t *testing.T) {
+	receiver := &flakyReceiver{failures: 1000}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	app, db := newTestApp(t, map[string]string{
+		"TLDR_WEBHOOK_URL": server.URL,
+		"TLDR_API_KEYS":    "admin,user",
+		"TLDR_ADMIN_KEYS":  "admin",
+	})
+	admin := []string{"X-API-Key", "admin"}
+	for _, short := range []string{"first", "second"} {
+		if err := db.InsertDeadLetter("'"+short+"'", []byte(`{"short":"`+short+`"}`), "webhook answered with status 500", 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	letters, err := db.DeadLetters(deadLetterListLimit)
+	if err != nil || len(letters) != 2 {
+		t.Fatalf("%d dead letters (%v), want 2", len(letters), err)
+	}
+	second, first := letters[0].ID, letters[1].ID
+
+	if status, resp := send(t, app, "GET", "/api/webhooks/deadletter", "", "X-API-Key", "user"); status != 403 {
+		t.Errorf("list with a user key: %d %s, want 403", status, resp.Code)
+	}
+	resp, body := sendRaw(t, app, "GET", "/api/webhooks/deadletter", "", admin...)
+	if resp.StatusCode != 200 || !strings.Contains(string(body), `"Payload":{"short":"second"}`) {
+		t.Errorf("list: %d %s", resp.StatusCode, body)
+	}
+
+	// The receiver is still down, the retry counts one more attempt.
+	retry := fmt.Sprintf("/api/webhooks/deadletter/%d/retry", first)
+	if status, resp := send(t, app, "POST", retry, "", admin...); status != 502 || resp.Code != "WEBHOOK_FAILED" {
+		t.Errorf("retry while down: %d %s, want 502 WEBHOOK_FAILED", status, resp.Code)
+	}
+	if _, letter, _ := db.GetDeadLetter(first); letter.Attempts != 4 {
+		t.Errorf("%d attempts after the failed retry, want 4", letter.Attempts)
+	}
+	atomic.StoreInt32(&receiver.failures, 0)
+	if status, resp := send(t, app, "POST", retry, "", admin...); status != 200 {
+		t.Errorf("retry: %d %s, want 200", status, resp.Message)
+	}
+	if found, _, _ := db.GetDeadLetter(first); found {
+		t.Error("the delivered dead letter is still there")
+	}
+	if status, _ := send(t, app, "POST", retry, "", admin...); status != 404 {
+		t.Errorf("retry of a delivered dead letter: %d, want 404", status)
+	}
+
+	posts := atomic.LoadInt32(&receiver.posts)
+	remove := fmt.Sprintf("/api/webhooks/deadletter/%d", second)
+	if status, resp := send(t, app, "DELETE", remove, "", admin...); status != 200 || resp.Data["ID"] != float64(second) {
+		t.Errorf("delete: %d %v, want 200 with the dead letter", status, resp.Data)
+	}
+	if status, _ := send(t, app, "DELETE", remove, "", admin...); status != 404 {
+		t.Errorf("second delete: %d, want 404", status)
+	}
+	if atomic.LoadInt32(&receiver.posts) != posts {
+		t.Error("deleting a dead letter delivered it")
+	}
+	if status, _ := send(t, app, "DELETE", "/api/webhooks/deadletter/x", "", admin...); status != 400 {
+		t.Errorf("delete of a non numeric id: %d, want 400", status)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	uri "net/url"
+)
+
+// splitWildcard :: split the redirect path into the short and the path to append to its url.
+// The whole path wins if it is a short ('team1/abc'), otherwise the first two and then the first segment are
+// tried, but only a wildcard url takes the rest of the path. Everything else is looked up as usual (and not found).
+func (h handler) splitWildcard(c *fiber.Ctx, path string) (string, string) {
+	if !strings.Contains(path, "/") {
+		return path, ""
+	}
+	db := h.dbFor(c)
+	if short, ok := VerifyShort(path); ok && IsValidShort(short) {
+		if exists, err := db.ShortExists(short); err != nil || exists {
+			return path, ""
+		}
+	}
+
+	segments := strings.SplitN(path, "/", 3)
+	candidates := []int{len(segments[0])}
+	if len(segments) == 3 {
+		candidates = []int{len(segments[0]) + 1 + len(segments[1]), len(segments[0])}
+	}
+	for _, end := range candidates {
+		short, ok := VerifyShort(path[:end])
+		if !ok || !IsValidShort(short) {
+			continue
+		}
+		found, url, err := db.GetUrlFromShort(short)
+		if err != nil {
+			log.Printf("ERROR: %s", err.Error())
+			break
+		} else if found && url.Wildcard {
+			rest := path[end+1:]
+			// The router drops a trailing slash, the target might care about it.
+			if strings.HasSuffix(c.Path(), "/") {
+				rest += "/"
+			}
+			return path[:end], rest
+		}
+	}
+	return path, ""
+}
+
+// expandTarget :: append the path (and the query of the request) to the url of a wildcard short,
+// 'https://example.com/docs' and 'setup/install' become 'https://example.com/docs/setup/install'.
+// The link password isn't passed on, it's meant for us and not for the target.
+func expandTarget(target, path, query string) string {
+	u, err := uri.Parse(target)
+	if err != nil {
+		return target
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path
+	u.RawPath = ""
+
+	params, err := uri.ParseQuery(query)
+	if err != nil || len(params) == 0 {
+		return u.String()
+	}
+	params.Del("password")
+	if len(params) == 0 {
+		return u.String()
+	}
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += params.Encode()
+	return u.String()
+}
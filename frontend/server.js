@@ -15,6 +15,8 @@ async function getRedirect(param) {
 			url: `${apiUrl}${param}`,
 			method: 'get',
 			timeout: 8000,
+			// The api mirrors the payload status as http status, we always want the payload.
+			validateStatus: () => true,
 			headers: {
 				'Content-Type': 'application/json',
 			}
@@ -36,6 +38,8 @@ async function createRedirect(url) {
 			url: `${apiUrl}`,
 			method: 'post',
 			timeout: 8000,
+			// The api mirrors the payload status as http status, we always want the payload.
+			validateStatus: () => true,
 			data: payload,
 			headers: {
 				'Content-Type': 'application/json',